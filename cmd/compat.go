@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/app"
+	"github.com/hvoyvodov/nodelocaldns/pkg/nanny"
+	"github.com/hvoyvodov/nodelocaldns/pkg/util"
+	"k8s.io/klog/v2"
+)
+
+// upstreamFlags are the flags of the k8s node-local-dns node-cache and its
+// embedded CoreDNS, any of them on the command line selects the compatibility mode
+var upstreamFlags = map[string]bool{
+	"localip":        true,
+	"setupinterface": true,
+	"interfacename":  true,
+	"syncinterval":   true,
+	"setupiptables":  true,
+	"skipteardown":   true,
+	"basecorefile":   true,
+	"corefile":       true,
+	"kubednscm":      true,
+	"upstreamsvc":    true,
+	"conf":           true,
+	"dns.port":       true,
+	"pidfile":        true,
+	"quiet":          true,
+	"plugins":        true,
+}
+
+// unsupportedUpstreamFlags are accepted in the compatibility mode but ignored
+var unsupportedUpstreamFlags = map[string]string{
+	"kubednscm": "stubDomains and upstreamNameservers of the kube-dns ConfigMap are not imported",
+	"pidfile":   "use -pid-path for the unbound pid file",
+	"quiet":     "use -v to set the log verbosity",
+	"plugins":   "there are no CoreDNS plugins",
+}
+
+// isCompatMode returns true when the arguments use the node-local-dns flags
+func isCompatMode(args []string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if upstreamFlags[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCompatFlags maps the node-local-dns flag set onto AppParams, the
+// Corefile is imported into the configuration on every change
+func parseCompatFlags() (*app.AppParams, error) {
+	params := &app.AppParams{
+		RunNannyOpts: &nanny.RunNannyOpts{},
+		SyncInterval: 10 * time.Second,
+	}
+
+	var baseCorefile, corefile, conf string
+	flag.StringVar(&params.LocalIPStr, "localip", "", "comma-separated string of ip addresses to bind dnscache to")
+	flag.BoolVar(&params.SetupInterface, "setupinterface", true, "indicates whether network interface should be setup")
+	flag.StringVar(&params.InterfaceName, "interfacename", "nodelocaldns", "name of the interface to be created")
+	flag.DurationVar(&params.Interval, "syncinterval", 60*time.Second, "interval to check for iptables rules")
+	flag.StringVar(&params.MetricsListenAddress, "metrics-listen-address", "0.0.0.0:9253", "address to serve metrics on")
+	flag.BoolVar(&params.SetupIptables, "setupiptables", true, "indicates whether iptables rules should be setup")
+	flag.StringVar(&baseCorefile, "basecorefile", "/etc/coredns/Corefile.base", "Path to the template Corefile for node-cache")
+	flag.StringVar(&corefile, "corefile", "/etc/Corefile", "Path to the Corefile, used when -basecorefile and -conf do not exist")
+	flag.StringVar(&conf, "conf", "", "Path to the CoreDNS Corefile, used when -basecorefile does not exist")
+	flag.StringVar(&params.UpstreamSvc, "upstreamsvc", "kube-dns", "Service name whose cluster IP is upstream for node-cache")
+	flag.StringVar(&params.HealthPort, "health-port", "8080", "port used by health plugin")
+	flag.BoolVar(&params.SkipTeardown, "skipteardown", false, "indicates whether iptables rules should be torn down on exit")
+	flag.IntVar(&params.RunNannyOpts.LocalPort, "dns.port", 53, "Port on which to listen for DNS requests")
+	flag.String("kubednscm", "/etc/kube-dns", "Not supported")
+	flag.String("pidfile", "", "Not supported")
+	flag.Bool("quiet", false, "Not supported")
+	flag.Bool("plugins", false, "Not supported")
+	registerCommonFlags(params)
+
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	klog.Info("Running with node-local-dns compatible flags")
+	resolvConfSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if reason, ok := unsupportedUpstreamFlags[f.Name]; ok {
+			klog.Warningf("Flag -%s is not supported and is ignored, %s", f.Name, reason)
+		}
+		resolvConfSet = resolvConfSet || f.Name == "resolv-conf"
+	})
+	// node-local-dns fills __PILLAR__UPSTREAM__SERVERS__ from the host resolv.conf
+	if !resolvConfSet {
+		params.ResolvConf = "/etc/resolv.conf"
+	}
+
+	switch {
+	case util.IsFileExists(baseCorefile):
+		params.Corefile = baseCorefile
+	case conf != "" && util.IsFileExists(conf):
+		params.Corefile = conf
+	default:
+		params.Corefile = corefile
+	}
+
+	if params.LocalIPStr == "" {
+		return params, fmt.Errorf("-localip is required")
+	}
+	return params, parseLocalIPs(params)
+}
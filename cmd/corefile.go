@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+	"gopkg.in/yaml.v2"
+)
+
+// runImportCorefile translates a node-local-dns Corefile into the node-cache
+// YAML configuration, the warnings are printed on stderr
+func runImportCorefile(args []string) error {
+	fs := flag.NewFlagSet("import-corefile", flag.ExitOnError)
+	output := fs.String("output", "", "File to write the configuration to, stdout when empty")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import-corefile [flags] <Corefile|->\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("a Corefile is required")
+	}
+
+	var in io.Reader = os.Stdin
+	source := fs.Arg(0)
+	if source != "-" {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	imp, err := config.ImportCorefile(in)
+	if err != nil {
+		return err
+	}
+	for _, warning := range imp.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if err := imp.Config.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: the imported configuration is not valid yet: %v\n", err)
+	}
+
+	data, err := yaml.Marshal(imp.Config)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Imported from Corefile %s\n", source)
+	if flags := corefileFlags(imp); len(flags) > 0 {
+		fmt.Fprintf(&buf, "# Run node-cache with: %s\n", strings.Join(flags, " "))
+	}
+	buf.WriteString("---\n")
+	buf.Write(data)
+
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0644)
+}
+
+// corefileFlags returns the node-cache flags for the settings of the Corefile
+// which are not part of the configuration
+func corefileFlags(imp *config.CorefileImport) []string {
+	var flags []string
+	if len(imp.BindAddresses) > 0 {
+		flags = append(flags, "-bind-address="+strings.Join(imp.BindAddresses, ","))
+	}
+	if imp.Port != 0 && imp.Port != 53 {
+		flags = append(flags, fmt.Sprintf("-port=%d", imp.Port))
+	}
+	if imp.HealthAddress != "" {
+		if _, port, err := net.SplitHostPort(imp.HealthAddress); err == nil && port != "" {
+			flags = append(flags, "-health-port="+port)
+		}
+	}
+	if imp.MetricsAddress != "" {
+		flags = append(flags, "-metrics-listen-address="+imp.MetricsAddress)
+	}
+	return flags
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,15 +16,27 @@ import (
 )
 
 var (
-	cacheApp app.CacheApp
-	version  string
+	cacheApp     app.CacheApp
+	version      string
+	queryLogPath string
 )
 
-func init() {
-	params, err := parseAndValidateFlags()
+func initApp() {
+	parse := parseAndValidateFlags
+	if isCompatMode(os.Args[1:]) {
+		parse = parseCompatFlags
+	}
+	params, err := parse()
 	if err != nil {
 		klog.Fatalf("Error parsing flags - %s, Exiting", err)
 	}
+	if r := params.RunNannyOpts.Resources; r.IOClass < 0 || r.IOClass > 3 || r.IOPriority < 0 || r.IOPriority > 7 {
+		klog.Fatalf("Invalid ionice class %d or priority %d, Exiting", r.IOClass, r.IOPriority)
+	}
+	params.Version = version
+	if params.RunNannyOpts.QueryLog, err = openQueryLog(queryLogPath); err != nil {
+		klog.Fatalf("Error opening the query log - %s, Exiting", err)
+	}
 
 	cacheApp = *app.NewCacheApp(params)
 	cacheApp.Init()
@@ -36,35 +51,125 @@ func parseAndValidateFlags() (*app.AppParams, error) {
 	flag.BoolVar(&params.SetupInterface, "setup-interface", true, "Set to false to skip dummy interface setup")
 	flag.StringVar(&params.ConfigFile, "config", "/etc/unbound/unbound.yaml", "Path to Unbound configuration for node-cache")
 	flag.DurationVar(&params.SyncInterval, "syncInterval", 10*time.Second, "Interval on which to check for configuration changes")
-	flag.DurationVar(&params.Interval, "netSyncInterval", 60*time.Second, "interval(in seconds) to check for iptables rules")
+	flag.DurationVar(&params.Interval, "netSyncInterval", 60*time.Second, "Interval on which to check the iptables rules and the interface")
 	flag.StringVar(&params.LocalIPStr, "bind-address", "169.254.25.10", "Comma-separated list of IPs to listen on")
 	flag.StringVar(&params.MetricsListenAddress, "metrics-listen-address", "0.0.0.0:9253", "address to serve metrics on")
+	flag.BoolVar(&params.SetupIptables, "setup-iptables", true, "indicates whether iptables rules should be setup")
+	flag.StringVar(&params.HealthPort, "health-port", "9254", "port used by health plugin")
+	registerCommonFlags(params)
+
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	return params, parseLocalIPs(params)
+}
+
+// registerCommonFlags registers the flags shared with the compatibility mode
+func registerCommonFlags(params *app.AppParams) {
 	flag.StringVar(&params.UnboundTemplatePath, "templatePath", "/etc/unbound/unbound.conf.tmpl", "Path to the template Unbound for node-cache")
 	flag.StringVar(&params.RunNannyOpts.Pid, "pid-path", "/var/run/unbound.pid", "Path to the pid file to be created")
+	flag.StringVar(&params.RunNannyOpts.StateDir, "state-dir", "/var/lib/unbound", "Writable directory for unbound state like auth-zone files")
+	flag.StringVar(&params.ResolvConf, "resolv-conf", "", "Path to the host resolv.conf, e.g. /etc/resolv.conf, its nameservers are the upstreams when no root zone is configured, disabled when empty")
 
-	flag.BoolVar(&params.SetupIptables, "setup-iptables", true, "indicates whether iptables rules should be setup")
-	flag.StringVar(&params.HealthPort, "health-port", "9254", "port used by health plugin")
+	// Kubernetes API related
+	flag.StringVar(&params.KubeConfig, "kubeconfig", "", "Path to a kubeconfig, the in-cluster credentials are used when empty")
+	flag.StringVar(&params.ClusterDNSService, "cluster-dns-service", "", "namespace/name of the cluster DNS Service (e.g. kube-system/kube-dns) to forward the cluster domain to, disabled when empty")
+	flag.BoolVar(&params.ClusterDNSEndpoints, "cluster-dns-endpoints", false, "Forward the cluster domain to the cluster DNS endpoints instead of the ClusterIP")
+	flag.StringVar(&params.ConfigMap, "configmap", "", "namespace/name of a ConfigMap to watch through the API instead of reading -config")
+	flag.StringVar(&params.ConfigMapKey, "configmap-key", "unbound.yaml", "Key of the ConfigMap holding the node-cache configuration")
+	flag.StringVar(&params.NodeName, "node-name", os.Getenv("NODE_NAME"), "Name of the Node to select configuration overrides for and record Events against, disabled when empty")
+	flag.StringVar(&params.PodName, "pod-name", os.Getenv("POD_NAME"), "Name of the Pod to record Events against, disabled when empty")
+	flag.StringVar(&params.PodNamespace, "pod-namespace", os.Getenv("POD_NAMESPACE"), "Namespace of the Pod")
 	// Nanny/Unbound related
 	flag.IntVar(&params.RunNannyOpts.LocalPort, "port", 53, "Port on which to listen for DNS requests")
 	flag.StringVar(&params.RunNannyOpts.Exec, "unboundExec", "/usr/local/sbin/unbound", "Path to unbound binary")
 	flag.StringVar(&params.RunNannyOpts.CheckExec, "unboundCheckConfExec", "/usr/local/sbin/unbound-checkconf", "Path to unbound-checkconf binary")
+	flag.DurationVar(&params.RunNannyOpts.RestartBackoff, "restart-backoff", time.Second, "Delay before unbound is restarted after it exits, doubled on every consecutive failure")
+	flag.DurationVar(&params.RunNannyOpts.MaxRestartBackoff, "max-restart-backoff", 30*time.Second, "Maximum delay before unbound is restarted")
+	flag.IntVar(&params.RunNannyOpts.MaxRestarts, "max-restarts", 5, "Consecutive unbound failures after which node-cache exits")
+	flag.DurationVar(&params.RunNannyOpts.RestartResetAfter, "restart-reset-after", 5*time.Minute, "Time unbound has to run for the restart backoff to be reset")
+	flag.StringVar(&queryLogPath, "query-log", "-", "Where unbound query and reply lines are written as JSON, - for stdout, empty to log them with the other unbound lines")
+	flag.BoolVar(&params.RunNannyOpts.PersistCache, "persist-cache", false, "Dump the unbound cache to -state-dir and load it after unbound starts")
+	flag.DurationVar(&params.RunNannyOpts.CacheDumpInterval, "cache-dump-interval", 10*time.Minute, "Interval on which the unbound cache is dumped, 0 dumps on shutdown only")
+	flag.DurationVar(&params.RunNannyOpts.CacheMaxAge, "cache-max-age", time.Hour, "Maximum age of a cache dump to be loaded")
+	flag.DurationVar(&params.DrainPeriod, "drain-period", 5*time.Second, "Time in-flight queries get to finish after the interface and iptables rules are removed on shutdown")
+	flag.DurationVar(&params.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "Timeout of every shutdown step, unbound is killed when it does not stop within it")
+	// Resource controls of unbound
+	resources := &params.RunNannyOpts.Resources
+	flag.Func("oom-score-adj", "oom_score_adj of unbound, from -1000 to 1000, inherited when unset", func(value string) error {
+		adj, err := strconv.Atoi(value)
+		if err != nil || adj < -1000 || adj > 1000 {
+			return fmt.Errorf("invalid oom_score_adj %q", value)
+		}
+		resources.OOMScoreAdj = &adj
+		return nil
+	})
+	flag.IntVar(&resources.Nice, "nice", 0, "Nice value of unbound, inherited when 0")
+	flag.IntVar(&resources.IOClass, "ionice-class", 0, "I/O scheduling class of unbound, 1 realtime, 2 best-effort, 3 idle, inherited when 0")
+	flag.IntVar(&resources.IOPriority, "ionice-priority", 4, "I/O priority of unbound within -ionice-class, from 0 to 7")
+	flag.Uint64Var(&resources.NoFileLimit, "nofile-limit", 0, "soft RLIMIT_NOFILE of unbound, never lowered below the inherited one, inherited when 0")
+	flag.Func("cpus", "Comma separated CPUs unbound is pinned to, inherited when empty", func(value string) error {
+		resources.CPUs = nil
+		for _, cpu := range strings.Split(value, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(cpu))
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid CPU %q", cpu)
+			}
+			resources.CPUs = append(resources.CPUs, n)
+		}
+		return nil
+	})
+}
 
-	klog.InitFlags(nil)
-	flag.Parse()
+// openQueryLog returns the destination of the unbound query and reply lines,
+// "-" is stdout and an empty path logs them with klog
+func openQueryLog(path string) (io.Writer, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return os.Stdout, nil
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
 
+func parseLocalIPs(params *app.AppParams) error {
 	for _, ipstr := range strings.Split(params.LocalIPStr, ",") {
 		newIP := net.ParseIP(ipstr)
 		if newIP == nil {
-			return params, fmt.Errorf("invalid localip specified - %q", ipstr)
+			return fmt.Errorf("invalid localip specified - %q", ipstr)
 		}
 
 		params.RunNannyOpts.LocalIPs = append(params.RunNannyOpts.LocalIPs, newIP)
 	}
 
-	return params, nil
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status":
+			if err := runStatus(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "import-corefile":
+			if err := runImportCorefile(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "validate":
+			if err := runValidate(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 
+	initApp()
 	cacheApp.Run()
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/kube"
+)
+
+// runStatus prints the status of the local node-cache, or with --all
+// the status published by every node-cache Pod in the cluster
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	all := fs.Bool("all", false, "Show the status of every node-cache Pod in the cluster")
+	address := fs.String("address", "127.0.0.1:9254", "Health server address of the local node-cache")
+	kubeconfig := fs.String("kubeconfig", "", "Path to a kubeconfig, the in-cluster credentials are used when empty")
+	namespace := fs.String("namespace", "kube-system", "Namespace of the node-cache Pods")
+	selector := fs.String("selector", "k8s-app=nodelocaldns", "Label selector of the node-cache Pods")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if !*all {
+		return printLocalStatus(ctx, *address)
+	}
+
+	client, err := kube.NewClient(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	list, err := kube.ListStatus(ctx, client, *namespace, *selector)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tCONFIG VERSION\tRESOURCE VERSION\tUNBOUND\tLAST RELOAD\tRESULT")
+	for _, status := range list {
+		version := status.ConfigVersion
+		if len(version) > 12 {
+			version = version[:12]
+		}
+		reload, result := "-", "ok"
+		if !status.LastReload.IsZero() {
+			reload = status.LastReload.Format(time.RFC3339)
+		}
+		if status.LastReloadError != "" {
+			result = status.LastReloadError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", status.Node, orDash(version),
+			orDash(status.ResourceVersion), orDash(status.UnboundVersion), reload, result)
+	}
+	return w.Flush()
+}
+
+func printLocalStatus(ctx context.Context, address string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/status", address), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("invalid status response: %v", err)
+	}
+	out, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/app"
+	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+	"github.com/hvoyvodov/nodelocaldns/pkg/nanny"
+	"gopkg.in/yaml.v2"
+)
+
+// runValidate renders a configuration file with the template and checks it
+// with unbound-checkconf, the errors are located in the template and the file
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configFile := fs.String("config", "/etc/unbound/unbound.yaml", "Path to the node-cache configuration to validate")
+	templatePath := fs.String("templatePath", "/etc/unbound/unbound.conf.tmpl", "Path to the template Unbound for node-cache")
+	bindAddress := fs.String("bind-address", "169.254.25.10", "Comma-separated list of IPs to listen on")
+	opts := &nanny.RunNannyOpts{}
+	fs.IntVar(&opts.LocalPort, "port", 53, "Port on which to listen for DNS requests")
+	fs.StringVar(&opts.StateDir, "state-dir", "/var/lib/unbound", "Writable directory for unbound state like auth-zone files")
+	fs.StringVar(&opts.Exec, "unboundExec", "/usr/local/sbin/unbound", "Path to unbound binary")
+	fs.StringVar(&opts.CheckExec, "unboundCheckConfExec", "/usr/local/sbin/unbound-checkconf", "Path to unbound-checkconf binary")
+	fs.Parse(args)
+
+	for _, ipstr := range strings.Split(*bindAddress, ",") {
+		ip := net.ParseIP(ipstr)
+		if ip == nil {
+			return fmt.Errorf("invalid bind address %q", ipstr)
+		}
+		opts.LocalIPs = append(opts.LocalIPs, ip)
+	}
+
+	data, err := os.ReadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	cfg := config.NewDefaultConfig()
+	if len(data) > 0 {
+		cfg = &config.Config{}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("unable to parse %s: %v", *configFile, err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%s is invalid: %v", *configFile, err)
+	}
+
+	if opts.Template, opts.TemplateSource, err = app.LoadTemplate(*templatePath); err != nil {
+		return err
+	}
+	err = nanny.NewNanny(opts).Check(cfg)
+	if checkconfErr, ok := err.(*config.CheckconfError); ok {
+		printCheckconfError(*templatePath, checkconfErr)
+		return fmt.Errorf("%s is rejected by unbound-checkconf", *configFile)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is valid\n", *configFile)
+	return nil
+}
+
+func printCheckconfError(templatePath string, err *config.CheckconfError) {
+	for _, p := range err.Problems {
+		if p.Line == 0 {
+			fmt.Println(p.Message)
+			continue
+		}
+		fmt.Printf("line %d: %s\n", p.Line, p.Message)
+		for _, line := range p.Context {
+			fmt.Printf("  %s\n", line)
+		}
+		for _, line := range p.TemplateLines {
+			fmt.Printf("  produced by %s:%d\n", templatePath, line)
+		}
+		if len(p.Fields) > 0 {
+			fmt.Printf("  configured by %s\n", strings.Join(p.Fields, ", "))
+		}
+	}
+}
@@ -1,24 +1,30 @@
 package app
 
 import (
+	"context"
 	"fmt"
-	"html/template"
+	"net"
 	"os"
 	"os/signal"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/Masterminds/sprig"
 	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
 	"github.com/hvoyvodov/nodelocaldns/pkg/healthz"
+	"github.com/hvoyvodov/nodelocaldns/pkg/kube"
 	"github.com/hvoyvodov/nodelocaldns/pkg/metrics"
 	appmetrics "github.com/hvoyvodov/nodelocaldns/pkg/metrics"
 	"github.com/hvoyvodov/nodelocaldns/pkg/nanny"
 	"github.com/hvoyvodov/nodelocaldns/pkg/netif"
-	"github.com/hvoyvodov/nodelocaldns/pkg/util"
+	"github.com/hvoyvodov/nodelocaldns/pkg/resolvconf"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	"k8s.io/kubernetes/pkg/util/iptables"
 	utilnet "k8s.io/utils/net"
@@ -39,6 +45,21 @@ type AppParams struct {
 	RunNannyOpts         *nanny.RunNannyOpts
 	ConfigFile           string
 	UnboundTemplatePath  string
+	KubeConfig           string        // kubeconfig to use instead of the in-cluster credentials
+	ClusterDNSService    string        // namespace/name of the Service to forward the cluster domain to
+	ClusterDNSEndpoints  bool          // forward to the Service endpoints instead of the ClusterIP
+	ConfigMap            string        // namespace/name of the ConfigMap to watch instead of ConfigFile
+	ConfigMapKey         string        // key of the ConfigMap holding the configuration
+	NodeName             string        // name of the Node, used to select configuration overrides
+	PodName              string        // name of the Pod, to record Events against
+	PodNamespace         string        // namespace of the Pod
+	Corefile             string        // node-local-dns Corefile imported instead of ConfigFile
+	UpstreamSvc          string        // Service whose ClusterIP replaces __PILLAR__CLUSTER__DNS__
+	ResolvConf           string        // resolv.conf whose nameservers replace __PILLAR__UPSTREAM__SERVERS__
+	SkipTeardown         bool          // keep the interface and iptables rules on exit
+	DrainPeriod          time.Duration // time in-flight queries get after the interception is withdrawn
+	ShutdownTimeout      time.Duration // bounds every shutdown step
+	Version              string        // version of node-cache
 }
 
 type iptablesRule struct {
@@ -53,9 +74,35 @@ type CacheApp struct {
 	params        *AppParams
 	netifHandle   *netif.NetifManager
 	exitChan      chan struct{}
+	exitOnce      sync.Once
+	healthzExit   chan struct{}
 	sigChan       chan os.Signal
 	lastError     error
 	healthzServer healthz.HealthServer
+	kubeClient    kubernetes.Interface
+	discovery     *kube.ServiceDiscovery
+	nodeWatcher   *kube.NodeWatcher
+	resolvConf    *resolvconf.Watcher
+	// networkingReady is set after the first networking setup, later changes are repairs
+	networkingReady bool
+	// networkLock keeps the periodic repair from racing the teardown
+	networkLock    sync.Mutex
+	networkingDown bool
+	shuttingDown   bool
+	statusLock     sync.RWMutex
+	override       string
+	configSync     *config.Sync
+	publisher      *kube.StatusPublisher
+	lastReload     time.Time
+	lastReloadErr  error
+}
+
+// appStatus reports the override applied on this node and the last reload
+type appStatus struct {
+	Node            string    `json:"node,omitempty"`
+	Override        string    `json:"override,omitempty"`
+	LastReload      time.Time `json:"lastReload,omitempty"`
+	LastReloadError string    `json:"lastReloadError,omitempty"`
 }
 
 func (c *CacheApp) Init() {
@@ -67,9 +114,43 @@ func (c *CacheApp) Init() {
 				Name:   "cacheapp",
 			},
 		},
+		ReadinessProviders: []healthz.Provider{
+			{
+				Handle: healthz.CheckFunc(c.Readyz),
+				Name:   "cacheapp",
+			},
+		},
+		StatusProviders: []healthz.StatusProvider{
+			{
+				Handle: c,
+				Name:   "cacheapp",
+			},
+		},
 	}
 
-	c.healthzServer = *healthz.NewHealthServer(&hinstance, c.params.HealthPort, c.exitChan)
+	c.healthzServer = *healthz.NewHealthServer(&hinstance, c.params.HealthPort, c.healthzExit)
+
+	if c.params.ClusterDNSService != "" {
+		c.initDiscovery()
+	}
+	if c.params.NodeName != "" {
+		c.initNodeWatcher()
+	}
+	if c.params.ResolvConf != "" {
+		c.resolvConf = resolvconf.NewWatcher(c.params.ResolvConf, c.params.SyncInterval)
+		c.healthzServer.Instance.StatusProviders = append(c.healthzServer.Instance.StatusProviders,
+			healthz.StatusProvider{Handle: c.resolvConf, Name: "resolvConf"})
+	}
+	if c.params.PodName != "" || c.params.NodeName != "" {
+		if client, err := c.kubernetesClient(); err == nil {
+			events.InitEvents(client, c.params.PodNamespace, c.params.PodName, c.params.NodeName)
+			if c.params.PodName != "" {
+				c.publisher = kube.NewStatusPublisher(client, c.params.PodNamespace, c.params.PodName)
+			}
+		} else {
+			klog.Errorf("Events and status publishing are disabled: %v", err)
+		}
+	}
 
 	if c.params.SetupInterface {
 		klog.V(1).Infof("Setup dummy network interface(s) with IPs: %v", c.params.RunNannyOpts.LocalIPs)
@@ -80,12 +161,171 @@ func (c *CacheApp) Init() {
 	}
 	c.setupNetworking()
 
-	if err := metrics.InitMetrics(c.params.MetricsListenAddress); err != nil {
+	if err := metrics.InitMetrics(c.params.MetricsListenAddress, config.UnboundControlSocket); err != nil {
 		c.lastError = err
 	}
 	c.lastError = nil
 }
 
+func (c *CacheApp) initDiscovery() {
+	client, err := c.kubernetesClient()
+	if err != nil {
+		klog.Errorf("Cluster DNS discovery is disabled: %v", err)
+		return
+	}
+	namespace, name := kube.SplitName(c.params.ClusterDNSService)
+	c.discovery = kube.NewServiceDiscovery(client, namespace, name, c.params.ClusterDNSEndpoints)
+	c.healthzServer.Instance.StatusProviders = append(c.healthzServer.Instance.StatusProviders,
+		healthz.StatusProvider{Handle: c.discovery, Name: "clusterDNS"})
+}
+
+func (c *CacheApp) initNodeWatcher() {
+	client, err := c.kubernetesClient()
+	if err != nil {
+		klog.Errorf("Node overrides are disabled: %v", err)
+		return
+	}
+	c.nodeWatcher = kube.NewNodeWatcher(client, c.params.NodeName, config.OverrideAnnotation)
+}
+
+// kubernetesClient returns the shared client for the kubernetes API
+func (c *CacheApp) kubernetesClient() (kubernetes.Interface, error) {
+	if c.kubeClient != nil {
+		return c.kubeClient, nil
+	}
+	client, err := kube.NewClient(c.params.KubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.kubeClient = client
+	return client, nil
+}
+
+// discoveryChanges returns the channel notified on cluster DNS changes,
+// nil (blocking forever) when discovery is disabled
+func (c *CacheApp) discoveryChanges() <-chan struct{} {
+	if c.discovery == nil {
+		return nil
+	}
+	return c.discovery.Changes()
+}
+
+// nodeChanges returns the channel notified on Node label changes,
+// nil (blocking forever) when overrides are disabled
+func (c *CacheApp) nodeChanges() <-chan struct{} {
+	if c.nodeWatcher == nil {
+		return nil
+	}
+	return c.nodeWatcher.Changes()
+}
+
+// resolvConfChanges returns the channel notified on nameserver changes,
+// nil (blocking forever) when the host resolv.conf is not used
+func (c *CacheApp) resolvConfChanges() <-chan struct{} {
+	if c.resolvConf == nil {
+		return nil
+	}
+	return c.resolvConf.Changes()
+}
+
+// defaultUpstreams returns the host nameservers usable as upstreams
+func (c *CacheApp) defaultUpstreams() []string {
+	if c.resolvConf == nil {
+		return nil
+	}
+	return c.upstreamNameservers(c.resolvConf.Nameservers())
+}
+
+// upstreamNameservers skips the nameservers which would forward to
+// ourselves: loopback, our own addresses, and the cluster DNS which the
+// interception sends back to us
+func (c *CacheApp) upstreamNameservers(nameservers []string) []string {
+	var servers []string
+	for _, server := range nameservers {
+		ip := net.ParseIP(server)
+		if ip == nil || ip.IsLoopback() || c.isLocalIP(ip) || c.isClusterDNSIP(ip) {
+			klog.V(2).Infof("Skipping nameserver %s of %s", server, c.params.ResolvConf)
+			continue
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func (c *CacheApp) isLocalIP(ip net.IP) bool {
+	for _, local := range c.params.RunNannyOpts.LocalIPs {
+		if local.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isClusterDNSIP returns true for the addresses of the cluster DNS Service,
+// discovered or published by the kubelet for -upstreamsvc
+func (c *CacheApp) isClusterDNSIP(ip net.IP) bool {
+	var addresses []string
+	if c.discovery != nil {
+		discovered := c.discovery.Status().(kube.DiscoveredService)
+		addresses = append(addresses, discovered.ClusterIPs...)
+		for _, endpoint := range discovered.Endpoints {
+			address, _, _ := strings.Cut(endpoint, "@")
+			addresses = append(addresses, address)
+		}
+	}
+	if address := c.upstreamSvcIP(); address != "" {
+		addresses = append(addresses, address)
+	}
+	for _, address := range addresses {
+		if ip.Equal(net.ParseIP(address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamSvcIP returns the ClusterIP the kubelet publishes for -upstreamsvc
+func (c *CacheApp) upstreamSvcIP() string {
+	if c.params.UpstreamSvc == "" {
+		return ""
+	}
+	return os.Getenv(strings.ToUpper(strings.ReplaceAll(c.params.UpstreamSvc, "-", "_")) + "_SERVICE_HOST")
+}
+
+// effectiveConfig returns the configuration with the node override applied,
+// the discovered cluster zones added and the host nameservers as default upstreams
+func (c *CacheApp) effectiveConfig(cfg *config.Config) *config.Config {
+	if c.nodeWatcher != nil {
+		var override string
+		cfg, override = cfg.ForNode(c.nodeWatcher.Labels())
+
+		c.statusLock.Lock()
+		if override != c.override {
+			klog.V(0).Infof("Node %s uses configuration override %q", c.params.NodeName, override)
+		}
+		c.override = override
+		c.statusLock.Unlock()
+	}
+	if c.discovery != nil {
+		cfg = cfg.WithClusterZones(c.discovery.Servers())
+	}
+	if c.resolvConf != nil {
+		cfg = cfg.WithDefaultUpstreams(c.defaultUpstreams())
+	}
+	return cfg
+}
+
+// Status reports the node, the selected configuration override and the last reload
+func (c *CacheApp) Status() interface{} {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+	status := appStatus{Node: c.params.NodeName, Override: c.override, LastReload: c.lastReload}
+	if c.lastReloadErr != nil {
+		status.LastReloadError = c.lastReloadErr.Error()
+	}
+	return status
+}
+
 func (c *CacheApp) initIptables() {
 	// using the localIPStr param since we need ip strings here
 
@@ -138,17 +378,35 @@ func (c *CacheApp) isIPv6() bool {
 
 func NewCacheApp(params *AppParams) *CacheApp {
 	return &CacheApp{
-		params:   params,
-		sigChan:  make(chan os.Signal, 1),
-		exitChan: make(chan struct{}, 1),
+		params:      params,
+		sigChan:     make(chan os.Signal, 1),
+		exitChan:    make(chan struct{}),
+		healthzExit: make(chan struct{}),
 	}
 }
 
 func (c *CacheApp) Healthz() error {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
 	return c.lastError
 }
 
+// Readyz fails once the node-cache is shutting down, to drain the traffic
+func (c *CacheApp) Readyz() error {
+	c.statusLock.RLock()
+	defer c.statusLock.RUnlock()
+	if c.shuttingDown {
+		return fmt.Errorf("node-cache is shutting down")
+	}
+	return nil
+}
+
 func (c *CacheApp) setupNetworking() {
+	c.networkLock.Lock()
+	defer c.networkLock.Unlock()
+	if c.networkingDown {
+		return
+	}
 	if c.params.SetupIptables {
 		for _, rule := range c.iptablesRules {
 			exists, err := c.iptables.EnsureRule(iptables.Prepend, rule.table, rule.chain, rule.args...)
@@ -158,6 +416,9 @@ func (c *CacheApp) setupNetworking() {
 				continue
 			case err == nil:
 				klog.Infof("Added back nodelocaldns rule - %v", rule)
+				if c.networkingReady {
+					events.Normal(events.ReasonIptablesRepaired, "Added back iptables rule %v", rule.args)
+				}
 				continue
 			default:
 				// iptables check/rule add failed with error since control reached here.
@@ -174,18 +435,32 @@ func (c *CacheApp) setupNetworking() {
 				appmetrics.PublishErrorMetric("interface_add")
 			}
 			klog.Infof("Added interface - %s", c.params.InterfaceName)
+			if c.networkingReady {
+				events.Normal(events.ReasonInterfaceRepaired, "Added back interface %s", c.params.InterfaceName)
+			}
 		}
 		if err != nil {
 			klog.Fatalf("Error checking dummy device %s - %s", c.params.InterfaceName, err)
 			appmetrics.PublishErrorMetric("interface_check")
 		}
 	}
+	c.networkingReady = true
 }
 
 func (c *CacheApp) TeardownNetworking() error {
+	// stops the periodic repair, closed instead of sent to so it never blocks
+	c.exitOnce.Do(func() { close(c.exitChan) })
+
+	c.networkLock.Lock()
+	defer c.networkLock.Unlock()
+	if c.networkingDown {
+		return nil
+	}
+	c.networkingDown = true
 	klog.V(1).Info("Tearing down")
-	if c.exitChan != nil {
-		c.exitChan <- struct{}{}
+	if c.params.SkipTeardown {
+		klog.V(1).Info("Keeping the interface and iptables rules")
+		return nil
 	}
 	var err error
 	if c.params.SetupInterface {
@@ -214,21 +489,18 @@ func (c *CacheApp) TeardownNetworking() error {
 	return err
 }
 
-func (c *CacheApp) runPeriodic() {
-	// if a pidfile is defined in flags, setup iptables as soon as it's created
-	if c.params.RunNannyOpts.Pid != "" {
-		for {
-			if util.IsFileExists(c.params.RunNannyOpts.Pid) {
-				break
-			}
-			klog.V(1).Infof("waiting for unbound pidfile '%s'", c.params.RunNannyOpts.Pid)
-			time.Sleep(time.Second * 1)
-		}
-		// we found the pidfile, coreDNS is running, we can setup networking early
+// runPeriodic repairs the iptables rules and the interface every Interval,
+// starting as soon as unbound is ready
+func (c *CacheApp) runPeriodic(ready <-chan struct{}) {
+	select {
+	case <-ready:
 		c.setupNetworking()
+	case <-c.exitChan:
+		return
 	}
 
-	tick := time.NewTicker(c.params.Interval * time.Second)
+	tick := time.NewTicker(c.params.Interval)
+	defer tick.Stop()
 	for {
 		select {
 		case <-tick.C:
@@ -241,20 +513,109 @@ func (c *CacheApp) runPeriodic() {
 }
 
 func (c *CacheApp) loadTemplate() error {
-	tplName := path.Base(c.params.UnboundTemplatePath)
-	tmpl, err := template.New(tplName).Funcs(sprig.FuncMap()).Funcs(template.FuncMap{
+	tmpl, source, err := LoadTemplate(c.params.UnboundTemplatePath)
+	if err != nil {
+		return err
+	}
+	c.params.RunNannyOpts.Template = tmpl
+	c.params.RunNannyOpts.TemplateSource = source
+	return nil
+}
+
+// LoadTemplate parses the unbound configuration template and returns it with its source
+func LoadTemplate(templatePath string) (*template.Template, string, error) {
+	source, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, "", fmt.Errorf("error getting unbound template: %v", err)
+	}
+	tplName := path.Base(templatePath)
+	tmpl, err := template.New(tplName).Funcs(sprig.TxtFuncMap()).Funcs(template.FuncMap{
 		"toYesNo": func(val bool) string {
 			if val {
 				return "yes"
 			}
 			return "no"
 		},
-	}).ParseFiles(c.params.UnboundTemplatePath)
+	}).Parse(string(source))
 	if err != nil {
-		return fmt.Errorf("error getting unbound template: %v", err)
+		return nil, "", fmt.Errorf("error getting unbound template: %v", err)
+	}
+	return tmpl, string(source), nil
+}
+
+// newSync returns the configuration source, the ConfigMap watched through
+// the API when one is set, and the mounted configuration file otherwise
+func (c *CacheApp) newSync(stopCh <-chan struct{}) *config.Sync {
+	if c.params.ConfigMap != "" {
+		client, err := c.kubernetesClient()
+		if err == nil {
+			namespace, name := kube.SplitName(c.params.ConfigMap)
+			source := config.NewConfigMapSource(client, namespace, name, c.params.ConfigMapKey,
+				path.Join(c.params.RunNannyOpts.StateDir, "configmap-cache.json"))
+			source.Start(stopCh)
+			if !source.WaitForSync(10 * time.Second) {
+				klog.Warningf("ConfigMap %s is not available yet, using the cached copy", c.params.ConfigMap)
+			}
+			return config.NewConfigMapSync(source, c.params.SyncInterval)
+		}
+		klog.Errorf("Unable to watch ConfigMap %s, using %s: %v", c.params.ConfigMap, c.params.ConfigFile, err)
+	}
+
+	if c.params.Corefile != "" {
+		sync := config.NewSync(c.params.Corefile, "", c.params.SyncInterval)
+		sync.Transform(c.corefileTransform())
+		return sync
+	}
+
+	// TODO: Make possible to add additional files here (plain configuration)
+	// which will be included in the main unbound configuration
+	return config.NewSync(c.params.ConfigFile, "", c.params.SyncInterval)
+}
+
+// reconfigure renders cfg and reloads unbound with it, unbound keeps
+// running with the previous configuration when cfg is rejected
+func (c *CacheApp) reconfigure(n *nanny.Nanny, cfg *config.Config) {
+	err := n.Configure(c.effectiveConfig(cfg))
+	if err == nil {
+		err = n.Reload()
+	}
+
+	c.statusLock.Lock()
+	c.lastReload = time.Now()
+	c.lastReloadErr = err
+	c.statusLock.Unlock()
+
+	c.publishStatus(n)
+}
+
+// publishStatus writes the applied configuration version and reload result to the Pod
+func (c *CacheApp) publishStatus(n *nanny.Nanny) {
+	if c.publisher == nil {
+		return
+	}
+	status := kube.NodeCacheStatus{
+		Node:           c.params.NodeName,
+		ConfigVersion:  n.ConfigVersion(),
+		UnboundVersion: n.Version(),
+	}
+	// the latest synced configuration may be rejected, its source and
+	// resource version only describe the active one when they match
+	if current := c.configSync.Current(); current.Version == status.ConfigVersion {
+		status.ConfigSource = current.Source
+		status.ResourceVersion = current.ResourceVersion
+	}
+	c.statusLock.RLock()
+	status.LastReload = c.lastReload
+	if c.lastReloadErr != nil {
+		status.LastReloadError = c.lastReloadErr.Error()
+	}
+	c.statusLock.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.publisher.Publish(ctx, status); err != nil {
+		klog.Warning(err)
 	}
-	c.params.RunNannyOpts.Template = tmpl
-	return nil
 }
 
 func (c *CacheApp) Run() {
@@ -262,19 +623,27 @@ func (c *CacheApp) Run() {
 	defer c.TeardownNetworking()
 
 	nanny := nanny.NewNanny(c.params.RunNannyOpts)
+	metrics.PublishBuildInfo(c.params.Version, nanny.Version())
 
 	c.healthzServer.Instance.Providers = append(c.healthzServer.Instance.Providers, healthz.Provider{Handle: nanny, Name: "nanny"})
+	c.healthzServer.Instance.ReadinessProviders = append(c.healthzServer.Instance.ReadinessProviders, healthz.Provider{Handle: healthz.CheckFunc(nanny.Readyz), Name: "unbound"})
+	c.healthzServer.Instance.StatusProviders = append(c.healthzServer.Instance.StatusProviders,
+		healthz.StatusProvider{Handle: nanny, Name: "unbound"},
+		healthz.StatusProvider{Handle: nanny.Resources(), Name: "resources"})
 
 	// We'll need to handle SIGHUP for reload, and SIGTERM/SIGINT to teardown network
 	signal.Notify(c.sigChan, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
 
-	go c.healthzServer.Start()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
 
-	// TODO: Make possible to add additional files here (plain configuration)
-	// which will be included in the main unbound configuration
-	sync := config.NewSync(c.params.ConfigFile, "", c.params.SyncInterval)
+	c.configSync = c.newSync(stopCh)
+	c.healthzServer.Instance.StatusProviders = append(c.healthzServer.Instance.StatusProviders,
+		healthz.StatusProvider{Handle: c.configSync, Name: "config"})
+
+	go c.healthzServer.Start()
 
-	currentConfig, err := sync.Once()
+	currentConfig, err := c.configSync.Once()
 	if err != nil {
 		klog.Errorf("Error getting initial config, using default: %v", err)
 		currentConfig = config.NewDefaultConfig()
@@ -285,16 +654,42 @@ func (c *CacheApp) Run() {
 		return
 	}
 
-	// Start periodic check and updates of the IPTables/Interface
-	go c.runPeriodic()
+	if c.discovery != nil {
+		c.discovery.Start(stopCh)
+		if !c.discovery.WaitForSync(10 * time.Second) {
+			klog.Warningf("Cluster DNS Service %s is not discovered yet", c.params.ClusterDNSService)
+		}
+	}
+	if c.nodeWatcher != nil {
+		c.nodeWatcher.Start(stopCh)
+		if !c.nodeWatcher.WaitForSync(10 * time.Second) {
+			klog.Warningf("Node %s is not available yet, overrides are not applied", c.params.NodeName)
+		}
+	}
+
+	if c.resolvConf != nil {
+		c.resolvConf.Start(stopCh)
+	}
 
-	nanny.Configure(currentConfig)
+	if err := nanny.Configure(c.effectiveConfig(currentConfig)); err != nil {
+		klog.Errorf("Initial configuration is rejected, using default: %v", err)
+		currentConfig = config.NewDefaultConfig()
+		nanny.Configure(c.effectiveConfig(currentConfig))
+	}
 	if err := nanny.Start(); err != nil {
 		c.TeardownNetworking()
 		klog.Fatalf("Could not start Unbound with initial configuration: %v", err)
 	}
 
-	configChan := sync.Periodic()
+	// Start periodic check and updates of the IPTables/Interface
+	go c.runPeriodic(nanny.Ready())
+
+	c.statusLock.Lock()
+	c.lastReload = time.Now()
+	c.statusLock.Unlock()
+	c.publishStatus(nanny)
+
+	configChan := c.configSync.Periodic()
 
 	for {
 		select {
@@ -302,27 +697,36 @@ func (c *CacheApp) Run() {
 			switch sig {
 			case syscall.SIGTERM, syscall.SIGINT:
 				klog.V(1).Info("Got SIGTERM. Will exit")
-				c.TeardownNetworking()
+				c.shutdown(nanny)
 				os.Exit(0)
 			case syscall.SIGHUP:
 				klog.V(1).Info("Got SIGHUP. Will reload all configs and templates")
 				if err := c.loadTemplate(); err != nil {
 					klog.Error(err)
 				}
-				nanny.Configure(currentConfig)
-				nanny.Reload()
+				c.reconfigure(nanny, currentConfig)
 			default:
 				klog.V(3).Infof("unhandled signal: %v", sig)
 			}
 
 		case status := <-nanny.ExitChannel:
+			// the nanny restarts unbound until it is crash looping
+			klog.Errorf("giving up on unbound: %v", status)
+			events.Warning(events.ReasonUnboundExited, "Giving up on unbound: %v", status)
 			klog.Flush()
-			klog.Errorf("unbound exited: %v", status)
 			return
 		case currentConfig = <-configChan:
 			klog.V(0).Infof("reloading unbound with new configuration")
-			nanny.Configure(currentConfig)
-			nanny.Reload()
+			c.reconfigure(nanny, currentConfig)
+		case <-c.nodeChanges():
+			klog.V(0).Infof("reloading unbound with new node labels")
+			c.reconfigure(nanny, currentConfig)
+		case <-c.discoveryChanges():
+			klog.V(0).Infof("reloading unbound with new cluster DNS servers %v", c.discovery.Servers())
+			c.reconfigure(nanny, currentConfig)
+		case <-c.resolvConfChanges():
+			klog.V(0).Infof("reloading unbound with new host nameservers %v", c.resolvConf.Nameservers())
+			c.reconfigure(nanny, currentConfig)
 		}
 	}
 }
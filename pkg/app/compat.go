@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+	"github.com/hvoyvodov/nodelocaldns/pkg/resolvconf"
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog/v2"
+)
+
+// corefileTransform returns a Sync transform which fills the __PILLAR__
+// placeholders of a node-local-dns Corefile and imports it into the YAML
+// configuration. The import is repeated only when the expanded Corefile
+// changes, and a Corefile with placeholders left unresolved is rejected.
+func (c *CacheApp) corefileTransform() func([]byte) ([]byte, error) {
+	var corefile, imported []byte
+	return func(data []byte) ([]byte, error) {
+		expanded, unresolved := config.ExpandPlaceholders(data, c.pillarValues())
+		if imported != nil && bytes.Equal(expanded, corefile) {
+			return imported, nil
+		}
+		if len(unresolved) > 0 {
+			return nil, fmt.Errorf("Corefile placeholders %s are not set", strings.Join(unresolved, ", "))
+		}
+
+		imp, err := config.ImportCorefile(bytes.NewReader(expanded))
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range imp.Warnings {
+			klog.Warningf("Corefile %s: %s", c.params.Corefile, warning)
+		}
+		out, err := yaml.Marshal(imp.Config)
+		if err != nil {
+			return nil, err
+		}
+		corefile, imported = expanded, out
+		return imported, nil
+	}
+}
+
+// pillarValues returns the values the upstream node-cache substitutes
+// in the Corefile, the others are taken from the environment
+func (c *CacheApp) pillarValues() map[string]string {
+	values := make(map[string]string)
+	localIPs := c.params.RunNannyOpts.LocalIPs
+	if len(localIPs) > 0 {
+		values["__PILLAR__LOCAL__DNS__"] = localIPs[0].String()
+	}
+	if len(localIPs) > 1 {
+		values["__PILLAR__DNS__SERVER__"] = localIPs[1].String()
+	}
+
+	// the kubelet publishes the ClusterIP of every Service in the namespace
+	if ip := c.upstreamSvcIP(); ip != "" {
+		values["__PILLAR__CLUSTER__DNS__"] = ip
+	}
+
+	if c.params.ResolvConf != "" {
+		rc, err := resolvconf.ParseFile(c.params.ResolvConf)
+		if err != nil {
+			klog.Warningf("Unable to read %s: %v", c.params.ResolvConf, err)
+		} else if servers := c.upstreamNameservers(rc.Nameservers); len(servers) > 0 {
+			values["__PILLAR__UPSTREAM__SERVERS__"] = strings.Join(servers, " ")
+		}
+	}
+	return values
+}
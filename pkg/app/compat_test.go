@@ -0,0 +1,85 @@
+package app
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/nanny"
+)
+
+func TestPillarValues(t *testing.T) {
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	data := "nameserver 169.254.20.10\nnameserver 10.96.0.10\nnameserver 127.0.0.53\nnameserver 10.0.0.2\nnameserver 10.0.0.3\n"
+	if err := os.WriteFile(resolvConf, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBE_DNS_UPSTREAM_SERVICE_HOST", "10.96.0.10")
+
+	c := NewCacheApp(&AppParams{
+		RunNannyOpts: &nanny.RunNannyOpts{LocalIPs: []net.IP{net.ParseIP("169.254.20.10"), net.ParseIP("10.96.0.10")}},
+		UpstreamSvc:  "kube-dns-upstream",
+		ResolvConf:   resolvConf,
+	})
+	want := map[string]string{
+		"__PILLAR__LOCAL__DNS__":        "169.254.20.10",
+		"__PILLAR__DNS__SERVER__":       "10.96.0.10",
+		"__PILLAR__CLUSTER__DNS__":      "10.96.0.10",
+		"__PILLAR__UPSTREAM__SERVERS__": "10.0.0.2 10.0.0.3",
+	}
+	if got := c.pillarValues(); !reflect.DeepEqual(got, want) {
+		t.Errorf("pillarValues() = %v, want %v", got, want)
+	}
+}
+
+func TestUpstreamNameservers(t *testing.T) {
+	t.Setenv("KUBE_DNS_SERVICE_HOST", "10.96.0.10")
+	c := NewCacheApp(&AppParams{
+		RunNannyOpts: &nanny.RunNannyOpts{LocalIPs: []net.IP{net.ParseIP("169.254.20.10")}},
+		UpstreamSvc:  "kube-dns",
+	})
+
+	tests := []struct {
+		name        string
+		nameservers []string
+		want        []string
+	}{
+		{"none", nil, nil},
+		{"upstreams", []string{"10.0.0.2", "2001:db8::53"}, []string{"10.0.0.2", "2001:db8::53"}},
+		{"loopback", []string{"127.0.0.53", "::1", "10.0.0.2"}, []string{"10.0.0.2"}},
+		{"local address", []string{"169.254.20.10", "10.0.0.2"}, []string{"10.0.0.2"}},
+		{"cluster DNS", []string{"10.96.0.10", "10.0.0.2"}, []string{"10.0.0.2"}},
+		{"only ourselves", []string{"169.254.20.10", "10.96.0.10"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.upstreamNameservers(tt.nameservers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("upstreamNameservers(%v) = %v, want %v", tt.nameservers, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorefileTransform(t *testing.T) {
+	c := NewCacheApp(&AppParams{
+		RunNannyOpts: &nanny.RunNannyOpts{LocalIPs: []net.IP{net.ParseIP("169.254.20.10")}},
+	})
+	transform := c.corefileTransform()
+
+	if _, err := transform([]byte(".:53 {\n bind __PILLAR__LOCAL__DNS__\n forward . 10.0.0.2\n}\n")); err != nil {
+		t.Errorf("transform() error = %v", err)
+	}
+
+	_, err := transform([]byte(".:53 {\n bind __PILLAR__LOCAL__DNS__ __PILLAR__DNS__SERVER__\n forward . __PILLAR__CLUSTER__DNS__\n}\n"))
+	if err == nil {
+		t.Fatal("transform() accepted unresolved placeholders")
+	}
+	for _, name := range []string{"__PILLAR__DNS__SERVER__", "__PILLAR__CLUSTER__DNS__"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("transform() error %q does not name %s", err, name)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// unboundStopper is the part of the nanny used by the shutdown
+type unboundStopper interface {
+	DumpCache() error
+	Stop(timeout time.Duration) error
+}
+
+// flushLogs writes the buffered logs once the shutdown completed
+var flushLogs = klog.Flush
+
+// shutdown stops node-cache in order: it reports not ready, withdraws the
+// interception of DNS traffic, lets in-flight queries finish for DrainPeriod,
+// persists the cache and stops unbound. Every step is bounded by
+// ShutdownTimeout, so the shutdown always completes.
+func (c *CacheApp) shutdown(n unboundStopper) {
+	start := time.Now()
+	timeout := c.params.ShutdownTimeout
+
+	c.statusLock.Lock()
+	c.shuttingDown = true
+	c.statusLock.Unlock()
+
+	runStep("withdrawing the interface and iptables rules", timeout, c.TeardownNetworking)
+
+	if c.params.DrainPeriod > 0 {
+		klog.V(0).Infof("Draining in-flight queries for %v", c.params.DrainPeriod)
+		time.Sleep(c.params.DrainPeriod)
+	}
+
+	runStep("persisting the unbound cache", timeout, n.DumpCache)
+	// Stop bounds the SIGTERM and the SIGKILL by timeout each
+	runStep("stopping unbound", 2*timeout+time.Second, func() error { return n.Stop(timeout) })
+
+	close(c.healthzExit)
+	klog.V(0).Infof("Shut down in %v", time.Since(start).Round(time.Millisecond))
+	flushLogs()
+}
+
+// runStep runs a shutdown step, giving up on it after timeout
+func runStep(step string, timeout time.Duration, fn func() error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		klog.Errorf("Shutdown: %s failed: %v", step, err)
+		return
+	}
+	klog.V(1).Infof("Shutdown: %s done", step)
+}
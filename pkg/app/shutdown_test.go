@@ -0,0 +1,101 @@
+package app
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNanny records the shutdown steps, the state of the CacheApp at each of
+// them shows the steps before
+type fakeNanny struct {
+	c     *CacheApp
+	start time.Time
+	drain time.Duration
+	// dumpDelay keeps DumpCache from returning
+	dumpDelay time.Duration
+
+	lock  sync.Mutex
+	steps []string
+}
+
+func (n *fakeNanny) record(step string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.steps = append(n.steps, step)
+}
+
+func (n *fakeNanny) before(step string) {
+	if n.c.Readyz() != nil {
+		n.record("not ready")
+	}
+	n.c.networkLock.Lock()
+	down := n.c.networkingDown
+	n.c.networkLock.Unlock()
+	if down {
+		n.record("teardown")
+	}
+	if time.Since(n.start) >= n.drain {
+		n.record("drained")
+	}
+	n.record(step)
+}
+
+func (n *fakeNanny) DumpCache() error {
+	n.before("dump cache")
+	time.Sleep(n.dumpDelay)
+	return nil
+}
+
+func (n *fakeNanny) Stop(timeout time.Duration) error {
+	n.record("stop " + timeout.String())
+	select {
+	case <-n.c.healthzExit:
+		n.record("healthz closed before stop")
+	default:
+	}
+	return errors.New("unbound did not exit after SIGKILL")
+}
+
+func TestShutdownOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		dumpDelay time.Duration
+	}{
+		{"all steps", 0},
+		// the timed out dump does not keep unbound from being stopped
+		{"dump timeout", time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flushed := false
+			flush := flushLogs
+			flushLogs = func() { flushed = true }
+			t.Cleanup(func() { flushLogs = flush })
+
+			c := NewCacheApp(&AppParams{ShutdownTimeout: 200 * time.Millisecond, DrainPeriod: 100 * time.Millisecond})
+			n := &fakeNanny{c: c, start: time.Now(), drain: c.params.DrainPeriod, dumpDelay: tt.dumpDelay}
+			c.shutdown(n)
+
+			want := []string{"not ready", "teardown", "drained", "dump cache", "stop 200ms"}
+			n.lock.Lock()
+			defer n.lock.Unlock()
+			if !reflect.DeepEqual(n.steps, want) {
+				t.Errorf("steps = %q, want %q", n.steps, want)
+			}
+			select {
+			case <-c.healthzExit:
+			default:
+				t.Errorf("the healthz server is not stopped")
+			}
+			if !flushed {
+				t.Errorf("the logs are not flushed")
+			}
+			if elapsed := time.Since(n.start); elapsed > time.Second {
+				t.Errorf("shutdown took %v", elapsed)
+			}
+		})
+	}
+}
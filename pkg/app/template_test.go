@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+)
+
+var templatePaths = []string{"../../build/etc/unbound.conf.tmpl", "../../example/unbound.conf.tmpl"}
+
+// renderTemplate renders the unbound configuration templates for c, by path
+func renderTemplate(t *testing.T, c *config.Config) map[string]string {
+	t.Helper()
+	rendered := make(map[string]string, len(templatePaths))
+	for _, templatePath := range templatePaths {
+		tmpl, _, err := LoadTemplate(templatePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, c); err != nil {
+			t.Fatalf("%s: %v", templatePath, err)
+		}
+		rendered[templatePath] = out.String()
+	}
+	return rendered
+}
+
+func TestTemplateLogTagQueryReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		queries bool
+		replies bool
+		want    string
+	}{
+		{"no query log", false, false, "log-tag-queryreply: no"},
+		{"queries", true, false, "log-tag-queryreply: yes"},
+		{"replies", false, true, "log-tag-queryreply: yes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.NewDefaultConfig()
+			c.Logging.Queries, c.Logging.Replies = tt.queries, tt.replies
+			for templatePath, out := range renderTemplate(t, c) {
+				if !strings.Contains(out, tt.want) {
+					t.Errorf("%s does not contain %q", templatePath, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateTrustAnchors(t *testing.T) {
+	anchors := []string{
+		`trust-anchor: ". DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"`,
+		`trust-anchor: ". DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"`,
+	}
+
+	c := config.NewDefaultConfig()
+	c.DNSSEC.Enabled = true
+	for templatePath, out := range renderTemplate(t, c) {
+		for _, anchor := range anchors {
+			if !strings.Contains(out, anchor) {
+				t.Errorf("%s does not contain %s", templatePath, anchor)
+			}
+		}
+		if strings.Contains(out, "auto-trust-anchor-file") {
+			t.Errorf("%s contains auto-trust-anchor-file without a trust anchor file", templatePath)
+		}
+	}
+
+	c.DNSSEC.TrustAnchorFile = "root.key"
+	c.StateDir = "/var/lib/unbound"
+	for templatePath, out := range renderTemplate(t, c) {
+		if !strings.Contains(out, `auto-trust-anchor-file: "/var/lib/unbound/root.key"`) {
+			t.Errorf("%s does not use the trust anchor file", templatePath)
+		}
+		if strings.Contains(out, "trust-anchor: ") {
+			t.Errorf("%s contains a static trust anchor with a trust anchor file", templatePath)
+		}
+	}
+}
+
+func TestTemplateUnboundVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    bool
+	}{
+		{"unknown version", "", true},
+		{"supported", "1.19.1", true},
+		{"too old", "1.9.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.NewDefaultConfig()
+			c.Unbound = config.UnboundBuild{Version: tt.version}
+			for templatePath, out := range renderTemplate(t, c) {
+				if got := strings.Contains(out, "serve-expired-client-timeout:"); got != tt.want {
+					t.Errorf("%s renders serve-expired-client-timeout: %v, want %v", templatePath, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestTemplateNotEscaped renders values which html/template escapes, the
+// configuration is not HTML
+func TestTemplateNotEscaped(t *testing.T) {
+	const url = "https://zones.example.com/axfr?zone=example.org&format=text"
+
+	c := config.NewDefaultConfig()
+	c.AuthZones = []config.ConfigAuthZone{{Name: "example.org", URLs: []string{url}, ForDownstream: true}}
+	for templatePath, out := range renderTemplate(t, c) {
+		if !strings.Contains(out, `url: "`+url+`"`) {
+			t.Errorf("%s does not contain the url %s", templatePath, url)
+		}
+	}
+
+	// html/template, used before, corrupted the url
+	var escaped bytes.Buffer
+	tmpl := htmltemplate.Must(htmltemplate.New("auth-zone").Parse(`url: "{{ . }}"`))
+	if err := tmpl.Execute(&escaped, url); err != nil {
+		t.Fatal(err)
+	}
+	if escaped.String() == `url: "`+url+`"` {
+		t.Errorf("html/template renders %s, the test does not cover escaping", escaped.String())
+	}
+}
@@ -0,0 +1,300 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// checkconfContextLines is the number of rendered lines shown around an error
+const checkconfContextLines = 2
+
+var (
+	// checkconfLinePattern matches "/etc/unbound/unbound.conf:87: error: syntax error"
+	checkconfLinePattern = regexp.MustCompile(`^(.+?):(\d+): (.*)$`)
+	// clausePattern matches a clause header like "forward-zone:"
+	clausePattern = regexp.MustCompile(`^([a-z][a-z0-9-]*):\s*(?:#.*)?$`)
+	// keywordPattern matches the keyword of an option line like "  num-threads: 2"
+	keywordPattern = regexp.MustCompile(`^\s*([a-z][a-z0-9-]*):`)
+	// actionPattern matches a template action
+	actionPattern = regexp.MustCompile(`{{-?\s*(.*?)\s*-?}}`)
+)
+
+// CheckconfError is an unbound-checkconf rejection of a rendered configuration,
+// with its errors located in the rendered file, the template and the Config
+type CheckconfError struct {
+	Output   string             `json:"output"`
+	Problems []CheckconfProblem `json:"problems,omitempty"`
+}
+
+// CheckconfProblem is a single error reported by unbound-checkconf
+type CheckconfProblem struct {
+	// Line is the rendered line, 0 when the error has no location
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+	// Context are the rendered lines around Line, prefixed with their number
+	Context []string `json:"context,omitempty"`
+	// TemplateLines are the template lines which may have produced Line
+	TemplateLines []int `json:"templateLines,omitempty"`
+	// Fields are the configuration fields used by TemplateLines, like forwardZones[].servers[]
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (e *CheckconfError) Error() string {
+	if len(e.Problems) == 0 {
+		return strings.TrimSpace(e.Output)
+	}
+	var msgs []string
+	for _, p := range e.Problems {
+		msgs = append(msgs, p.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (p CheckconfProblem) String() string {
+	msg := p.Message
+	if p.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", p.Line, msg)
+	}
+	var source []string
+	if len(p.TemplateLines) > 0 {
+		lines := make([]string, len(p.TemplateLines))
+		for i, line := range p.TemplateLines {
+			lines[i] = strconv.Itoa(line)
+		}
+		source = append(source, "template line "+strings.Join(lines, ", "))
+	}
+	if len(p.Fields) > 0 {
+		source = append(source, "field "+strings.Join(p.Fields, ", "))
+	}
+	if len(source) > 0 {
+		msg += " (" + strings.Join(source, ", ") + ")"
+	}
+	return msg
+}
+
+// ParseCheckconf locates the errors of unbound-checkconf output for the
+// configuration rendered to path, from the template source tmpl
+func ParseCheckconf(output, path string, rendered []byte, tmpl string) *CheckconfError {
+	result := &CheckconfError{Output: output}
+	renderedLines := strings.Split(string(rendered), "\n")
+	sources := templateSources(tmpl)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "read "+path+" failed") {
+			continue
+		}
+		m := checkconfLinePattern.FindStringSubmatch(line)
+		if m == nil || m[1] != path {
+			result.Problems = append(result.Problems, CheckconfProblem{Message: line})
+			continue
+		}
+		number, _ := strconv.Atoi(m[2])
+		problem := CheckconfProblem{Line: number, Message: m[3]}
+		if number > 0 && number <= len(renderedLines) {
+			problem.Context = lineContext(renderedLines, number)
+			problem.TemplateLines, problem.Fields = sources.locate(renderedLines, number)
+		}
+		result.Problems = append(result.Problems, problem)
+	}
+	return result
+}
+
+func lineContext(lines []string, number int) []string {
+	var context []string
+	for i := number - checkconfContextLines; i <= number+checkconfContextLines; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == number {
+			marker = ">"
+		}
+		context = append(context, fmt.Sprintf("%s%4d | %s", marker, i, lines[i-1]))
+	}
+	return context
+}
+
+// templateSource is a template line producing a configuration option
+type templateSource struct {
+	line    int
+	clause  string
+	keyword string
+	literal string
+	fields  []string
+}
+
+type templateSourceList []templateSource
+
+// locate returns the template lines and fields which may have produced the
+// rendered line, matched by the clause and the keyword of the line
+func (sources templateSourceList) locate(rendered []string, number int) ([]int, []string) {
+	clause := ""
+	for i := number - 1; i >= 0; i-- {
+		if m := clausePattern.FindStringSubmatch(rendered[i]); m != nil {
+			clause = m[1]
+			break
+		}
+	}
+	text := strings.TrimSpace(rendered[number-1])
+	keyword := ""
+	if m := keywordPattern.FindStringSubmatch(text); m != nil {
+		keyword = m[1]
+	}
+
+	var lines []int
+	var fields []string
+	seen := make(map[string]bool)
+	for _, s := range sources {
+		if s.clause != clause {
+			continue
+		}
+		if keyword != "" && s.keyword != keyword || keyword == "" && s.literal != text {
+			continue
+		}
+		lines = append(lines, s.line)
+		for _, field := range s.fields {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	return lines, fields
+}
+
+// templateScope is the dot of a template block, the type is nil when the
+// dot is not a configuration field
+type templateScope struct {
+	path string
+	typ  reflect.Type
+}
+
+// templateSources reads the clause, the keyword and the configuration fields
+// of every template line. The dot of range and with blocks is followed.
+func templateSources(tmpl string) templateSourceList {
+	var sources templateSourceList
+	root := templateScope{typ: reflect.TypeOf(Config{})}
+	scopes := []templateScope{root}
+	clause := ""
+
+	for i, line := range strings.Split(tmpl, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if m := clausePattern.FindStringSubmatch(line); m != nil {
+			clause = m[1]
+		}
+
+		var fields []string
+		for _, action := range actionPattern.FindAllStringSubmatch(line, -1) {
+			words := strings.Fields(action[1])
+			if len(words) == 0 {
+				continue
+			}
+			dot := scopes[len(scopes)-1]
+			switch words[0] {
+			case "end":
+				if len(scopes) > 1 {
+					scopes = scopes[:len(scopes)-1]
+				}
+				continue
+			case "range", "with":
+				scope := templateScope{}
+				if ref := pipelineRef(words[1:]); ref != "" {
+					scope = resolveRef(root, dot, ref)
+					if words[0] == "range" && scope.typ != nil {
+						scope = elemScope(scope)
+					}
+				}
+				scopes = append(scopes, scope)
+				continue
+			case "if", "define", "block":
+				scopes = append(scopes, dot)
+			case "else", "template":
+				continue
+			}
+			for _, word := range words {
+				ref := strings.Trim(word, "()")
+				if ref != "." && !strings.HasPrefix(ref, ".") && !strings.HasPrefix(ref, "$.") {
+					continue
+				}
+				if scope := resolveRef(root, dot, ref); scope.typ != nil && scope.path != "" {
+					fields = append(fields, scope.path)
+				}
+			}
+		}
+
+		source := templateSource{line: i + 1, clause: clause, fields: fields}
+		text := strings.TrimSpace(actionPattern.ReplaceAllString(line, ""))
+		if m := keywordPattern.FindStringSubmatch(text); m != nil {
+			source.keyword = m[1]
+		}
+		source.literal = text
+		if text != "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// pipelineRef returns the field referenced by a range or with pipeline,
+// like .Views in "$view := .Views"
+func pipelineRef(words []string) string {
+	for _, word := range words {
+		if strings.HasPrefix(word, ".") || strings.HasPrefix(word, "$.") {
+			return word
+		}
+	}
+	return ""
+}
+
+// resolveRef resolves a field reference like .Cache.MinTTL relative to dot
+// to the path of the YAML field, methods and runtime fields have no type
+func resolveRef(root, dot templateScope, ref string) templateScope {
+	scope := dot
+	if strings.HasPrefix(ref, "$") {
+		scope = root
+		ref = strings.TrimPrefix(ref, "$")
+	}
+	if ref == "." {
+		return scope
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(ref, "."), ".") {
+		t := scope.typ
+		if t == nil {
+			return templateScope{}
+		}
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return templateScope{}
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			return templateScope{}
+		}
+		tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if tag == "-" || tag == "" {
+			return templateScope{}
+		}
+		path := tag
+		if scope.path != "" {
+			path = scope.path + "." + tag
+		}
+		scope = templateScope{path: path, typ: field.Type}
+	}
+	return scope
+}
+
+// elemScope is the scope of the elements ranged over
+func elemScope(scope templateScope) templateScope {
+	if scope.typ.Kind() != reflect.Slice {
+		return templateScope{}
+	}
+	return templateScope{path: scope.path + "[]", typ: scope.typ.Elem()}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+const checkconfTemplate = `server:
+  # comment
+  num-threads: {{ .NumThreads }}
+  verbosity: 1
+{{ range .ForwardZones }}
+forward-zone:
+  name: "{{ .Name }}"
+  {{- range .Servers }}
+  forward-addr: {{ . }}
+  {{- end }}
+{{ end }}
+remote-control:
+  control-enable: yes
+`
+
+// renderCheckconfTemplate renders checkconfTemplate for the line numbers of the fixtures
+func renderCheckconfTemplate(t *testing.T, c *Config) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	if err := template.Must(template.New("unbound.conf").Parse(checkconfTemplate)).Execute(&out, c); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestParseCheckconf(t *testing.T) {
+	const path = "/etc/unbound/unbound.conf.new"
+	c := NewDefaultConfig()
+	c.NumThreads = 0
+	c.ForwardZones = []ConfigZone{{Name: "example.com.", Servers: []string{"10.0.0.2", "10.0.0.300"}}}
+	rendered := renderCheckconfTemplate(t, c)
+
+	tests := []struct {
+		name   string
+		output string
+		want   []CheckconfProblem
+	}{
+		{
+			name:   "option",
+			output: path + ":3: error: num-threads: number must be positive\nread " + path + " failed: 1 errors in configuration file\n",
+			want: []CheckconfProblem{{
+				Line:    3,
+				Message: "error: num-threads: number must be positive",
+				Context: []string{
+					"    1 | server:",
+					"    2 |   # comment",
+					">   3 |   num-threads: 0",
+					"    4 |   verbosity: 1",
+					"    5 | ",
+				},
+				TemplateLines: []int{3},
+				Fields:        []string{"numThreads"},
+			}},
+		},
+		{
+			name:   "ranged field",
+			output: path + ":9: error: cannot parse forward 10.0.0.300 ip address: '10.0.0.300'\n",
+			want: []CheckconfProblem{{
+				Line:    9,
+				Message: "error: cannot parse forward 10.0.0.300 ip address: '10.0.0.300'",
+				Context: []string{
+					"    7 |   name: \"example.com.\"",
+					"    8 |   forward-addr: 10.0.0.2",
+					">   9 |   forward-addr: 10.0.0.300",
+					"   10 | ",
+					"   11 | remote-control:",
+				},
+				TemplateLines: []int{9},
+				Fields:        []string{"forwardZones[].servers[]"},
+			}},
+		},
+		{
+			name:   "clause",
+			output: path + ":6: error: syntax error\n",
+			want: []CheckconfProblem{{
+				Line:    6,
+				Message: "error: syntax error",
+				Context: []string{
+					"    4 |   verbosity: 1",
+					"    5 | ",
+					">   6 | forward-zone:",
+					"    7 |   name: \"example.com.\"",
+					"    8 |   forward-addr: 10.0.0.2",
+				},
+				TemplateLines: []int{6},
+			}},
+		},
+		{
+			name:   "other file",
+			output: "/etc/unbound/unbound.conf.d/extra.conf:2: error: unknown keyword 'foo'\n",
+			want:   []CheckconfProblem{{Message: "/etc/unbound/unbound.conf.d/extra.conf:2: error: unknown keyword 'foo'"}},
+		},
+		{
+			name:   "no location",
+			output: "fatal error: could not open autotrust file for writing\n",
+			want:   []CheckconfProblem{{Message: "fatal error: could not open autotrust file for writing"}},
+		},
+		{
+			name:   "line out of range",
+			output: path + ":99: error: syntax error\n",
+			want:   []CheckconfProblem{{Line: 99, Message: "error: syntax error"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseCheckconf(tt.output, path, rendered, checkconfTemplate)
+			if got.Output != tt.output {
+				t.Errorf("Output = %q, want %q", got.Output, tt.output)
+			}
+			if !reflect.DeepEqual(got.Problems, tt.want) {
+				t.Errorf("Problems = %#v, want %#v", got.Problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckconfErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *CheckconfError
+		want string
+	}{
+		{
+			name: "output only",
+			err:  &CheckconfError{Output: "unbound-checkconf failed\n"},
+			want: "unbound-checkconf failed",
+		},
+		{
+			name: "located",
+			err: &CheckconfError{Problems: []CheckconfProblem{
+				{Line: 10, Message: "error: syntax error", TemplateLines: []int{9, 12}, Fields: []string{"forwardZones[].servers[]"}},
+				{Message: "fatal error: no configuration"},
+			}},
+			want: "line 10: error: syntax error (template line 9, 12, field forwardZones[].servers[]); fatal error: no configuration",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("%s: Error() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateSourcesSkipComments(t *testing.T) {
+	for _, s := range templateSources(checkconfTemplate) {
+		if strings.HasPrefix(s.literal, "#") {
+			t.Errorf("comment line %d is a source", s.line)
+		}
+	}
+}
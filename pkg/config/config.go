@@ -1,47 +1,263 @@
 package config
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
 
 const (
 	UnboundConfigPath = "/etc/unbound/unbound.conf"
+	// UnboundControlSocket is the remote-control interface used by node-cache
+	UnboundControlSocket = "/var/run/unbound-control.sock"
 )
 
+// memorySizePattern matches unbound memory sizes like 4m or 1048576
+var memorySizePattern = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
 type Config struct {
-	Cache           ConfigCache  `yaml:"cache"`
-	ForwardZones    []ConfigZone `yaml:"forwardZones"`
-	StubZones       []ConfigZone `yaml:"stubZones"`
-	TCPUpstream     bool         `yaml:"tcpUpstream"`
-	RoundRobin      bool         `yaml:"roundRobin"`
-	RateLimit       int          `yaml:"rateLimit"`
-	NumThreads      int          `yaml:"numThreads"`
-	Verbosity       int          `yaml:"verbosity"`
-	Port            int
-	Logging         ConfigLogging `yaml:"logging"`
-	AdditionalFiles []string
-	Interfaces      []net.IP
-	Pid             string
+	Cache           ConfigCache   `yaml:"cache,omitempty"`
+	ForwardZones    []ConfigZone  `yaml:"forwardZones,omitempty"`
+	StubZones       []ConfigZone  `yaml:"stubZones,omitempty"`
+	TCPUpstream     bool          `yaml:"tcpUpstream,omitempty"`
+	RoundRobin      bool          `yaml:"roundRobin,omitempty"`
+	RateLimit       int           `yaml:"rateLimit,omitempty"`
+	NumThreads      int           `yaml:"numThreads,omitempty"`
+	Verbosity       int           `yaml:"verbosity,omitempty"`
+	Port            int           `yaml:"-"`
+	Logging         ConfigLogging `yaml:"logging,omitempty"`
+	AdditionalFiles []string      `yaml:"-"`
+	Interfaces      []net.IP      `yaml:"-"`
+	Pid             string        `yaml:"-"`
+	StateDir        string        `yaml:"-"`
+	// ControlInterface is the unix socket of the remote-control, set by the nanny
+	ControlInterface string `yaml:"-"`
+	// Version identifies the configuration data this Config is parsed from
+	Version string `yaml:"-"`
+	// Unbound is the build the configuration is rendered for, set by the nanny
+	Unbound UnboundBuild `yaml:"-"`
+
+	// Zone based ratelimit, complementing the global RateLimit
+	RateLimitFactor      *int                `yaml:"ratelimitFactor,omitempty"`
+	RateLimitSize        string              `yaml:"ratelimitSize,omitempty"`
+	RateLimitSlabs       int                 `yaml:"ratelimitSlabs,omitempty"`
+	RateLimitForDomain   []ConfigDomainLimit `yaml:"ratelimitForDomain,omitempty"`
+	RateLimitBelowDomain []ConfigDomainLimit `yaml:"ratelimitBelowDomain,omitempty"`
+
+	// Per client IP ratelimit
+	IPRateLimit       int    `yaml:"ipRateLimit,omitempty"`
+	IPRateLimitFactor *int   `yaml:"ipRateLimitFactor,omitempty"`
+	IPRateLimitSize   string `yaml:"ipRateLimitSize,omitempty"`
+	IPRateLimitSlabs  int    `yaml:"ipRateLimitSlabs,omitempty"`
+
+	RebindingProtection ConfigRebindingProtection `yaml:"rebindingProtection,omitempty"`
+
+	AuthZones []ConfigAuthZone `yaml:"authZones,omitempty"`
+
+	Views       []ConfigView       `yaml:"views,omitempty"`
+	ViewClients []ConfigViewClient `yaml:"viewClients,omitempty"`
+
+	DNSSEC ConfigDNSSEC `yaml:"dnssec,omitempty"`
+	DNS64  ConfigDNS64  `yaml:"dns64,omitempty"`
+
+	Kubernetes ConfigKubernetes `yaml:"kubernetes,omitempty"`
+
+	// UnblockLANZones removes the default RFC1918 reverse zones, it is
+	// always set when reverse lookups are forwarded to the cluster DNS
+	UnblockLANZones bool `yaml:"unblockLANZones,omitempty"`
+
+	Overrides []ConfigOverride `yaml:"overrides,omitempty"`
+}
+
+// OverrideAnnotation on a Node selects an override by name, ahead of the node selectors
+const OverrideAnnotation = "nodelocaldns/override"
+
+// ConfigOverride changes the configuration of the nodes it selects
+type ConfigOverride struct {
+	Name string `yaml:"name,omitempty"`
+	// NodeSelector is a label selector, like "pool=gpu,zone in (a,b)"
+	NodeSelector string `yaml:"nodeSelector,omitempty"`
+	NumThreads   int    `yaml:"numThreads,omitempty"`
+	// ForwardZones replace the zones with the same name and add the others
+	ForwardZones []ConfigZone `yaml:"forwardZones,omitempty"`
+}
+
+type ConfigKubernetes struct {
+	// ClusterDomain defaults to cluster.local
+	ClusterDomain string `yaml:"clusterDomain,omitempty"`
+	// SearchPathOptimisation answers NXDOMAIN locally for names built by the
+	// ndots:5 search path which can never exist in the cluster domain,
+	// like example.com.svc.cluster.local or example.com.cluster.local
+	SearchPathOptimisation bool `yaml:"searchPathOptimisation,omitempty"`
+	// SearchPathTLDs are answered with NXDOMAIN as <tld>.svc.<domain> and
+	// <tld>.<domain>. Any label can be a namespace name, so only labels which
+	// are known not to be used as namespaces in the cluster may be listed.
+	SearchPathTLDs []string `yaml:"searchPathTLDs,omitempty"`
+	// SearchPathMaxLabels answers NXDOMAIN for names with more labels under
+	// svc.<domain>, 4 allows _port._proto.<service>.<namespace>. It needs
+	// unbound built with the python module, 0 disables it.
+	SearchPathMaxLabels int `yaml:"searchPathMaxLabels,omitempty"`
+}
+
+const DefaultClusterDomain = "cluster.local"
+
+type ConfigDNSSEC struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// TrustAnchorFile is kept up to date by unbound (RFC 5011) and is relative
+	// to the state directory. The root KSK is used as a static anchor when empty.
+	TrustAnchorFile string `yaml:"trustAnchorFile,omitempty"`
+}
+
+// TrustAnchorFilePath returns the location of the trust anchor inside stateDir
+func (d ConfigDNSSEC) TrustAnchorFilePath(stateDir string) string {
+	if filepath.IsAbs(d.TrustAnchorFile) {
+		return d.TrustAnchorFile
+	}
+	return filepath.Join(stateDir, d.TrustAnchorFile)
+}
+
+// ConfigDNS64 synthesises AAAA records from A records using the NAT64 prefix
+type ConfigDNS64 struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Prefix defaults to the well-known 64:ff9b::/96
+	Prefix string `yaml:"prefix,omitempty"`
+	// Synthall synthesises AAAA records even when real ones exist
+	Synthall bool `yaml:"synthall,omitempty"`
+	// IgnoreAAAA lists names whose AAAA records are ignored and synthesised instead
+	IgnoreAAAA []string `yaml:"ignoreAAAA,omitempty"`
+}
+
+// ConfigView holds local zones and data answered only to the clients mapped
+// to the view through ViewClients. Unbound views cannot carry forward or stub
+// zones, a view overrides resolution by answering locally instead.
+type ConfigView struct {
+	Name       string            `yaml:"name,omitempty"`
+	LocalZones []ConfigLocalZone `yaml:"localZones,omitempty"`
+	LocalData  []string          `yaml:"localData,omitempty"`
+	// ViewFirst falls back to the global local zones when nothing matches in the view
+	ViewFirst bool `yaml:"viewFirst,omitempty"`
+	// ForwardZones are rejected, unbound views hold local zones and local
+	// data only, a view cannot forward differently
+	ForwardZones []ConfigZone `yaml:"forwardZones,omitempty"`
+}
+
+type ConfigLocalZone struct {
+	Name string `yaml:"name,omitempty"`
+	Type string `yaml:"type,omitempty"`
+}
+
+// ConfigViewClient maps a client netblock to a view
+type ConfigViewClient struct {
+	CIDR string `yaml:"cidr,omitempty"`
+	View string `yaml:"view,omitempty"`
+}
+
+var localZoneTypes = map[string]bool{
+	"transparent":        true,
+	"typetransparent":    true,
+	"redirect":           true,
+	"inform":             true,
+	"inform_deny":        true,
+	"inform_redirect":    true,
+	"deny":               true,
+	"refuse":             true,
+	"static":             true,
+	"always_transparent": true,
+	"block_a":            true,
+	"block_aaaa":         true,
+	"always_refuse":      true,
+	"always_nxdomain":    true,
+	"always_null":        true,
+	"noview":             true,
+	"nodefault":          true,
+}
+
+type ConfigDomainLimit struct {
+	Name  string `yaml:"name,omitempty"`
+	Limit int    `yaml:"limit,omitempty"`
 }
 
 type ConfigLogging struct {
-	Queries  bool `yaml:"queries"`
-	Replies  bool `yaml:"replies"`
-	Servfail bool `yaml:"servfail"`
+	Queries  bool `yaml:"queries,omitempty"`
+	Replies  bool `yaml:"replies,omitempty"`
+	Servfail bool `yaml:"servfail,omitempty"`
 }
 
 type ConfigCache struct {
-	MaxTTL                    int  `yaml:"maxTTL"`
-	MinTTL                    int  `yaml:"minTTL"`
-	NegativeMaxTTL            int  `yaml:"negativeMaxTTL"`
-	Prefetch                  bool `yaml:"prefetch"`
-	ServeExpired              bool `yaml:"serveExpired"`
-	ServeExpiredTTL           int  `yaml:"serveExpiredTTL"`
-	ServeExpiredClientTimeout int  `yaml:"serveExpiredClientTimeout"`
+	MaxTTL                    int  `yaml:"maxTTL,omitempty"`
+	MinTTL                    int  `yaml:"minTTL,omitempty"`
+	NegativeMaxTTL            int  `yaml:"negativeMaxTTL,omitempty"`
+	Prefetch                  bool `yaml:"prefetch,omitempty"`
+	ServeExpired              bool `yaml:"serveExpired,omitempty"`
+	ServeExpiredTTL           int  `yaml:"serveExpiredTTL,omitempty"`
+	ServeExpiredClientTimeout int  `yaml:"serveExpiredClientTimeout,omitempty"`
+}
+
+// ConfigRebindingProtection strips private addresses from upstream answers,
+// except for the listed private domains and the configured stub and forward zones
+type ConfigRebindingProtection struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+	// PrivateAddresses replaces DefaultPrivateAddresses when set
+	PrivateAddresses []string `yaml:"privateAddresses,omitempty"`
+	PrivateDomains   []string `yaml:"privateDomains,omitempty"`
+}
+
+// DefaultPrivateAddresses are the RFC 1918, link-local and RFC 4193 ULA ranges
+var DefaultPrivateAddresses = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// ConfigAuthZone is a zone held locally in full, transferred from primaries
+// or downloaded from URLs, and stored in a zonefile
+type ConfigAuthZone struct {
+	Name      string   `yaml:"name,omitempty"`
+	Primaries []string `yaml:"primaries,omitempty"`
+	URLs      []string `yaml:"urls,omitempty"`
+	// ZoneFile is relative to the state directory, defaults to <name>.zone
+	ZoneFile        string `yaml:"zoneFile,omitempty"`
+	ForDownstream   bool   `yaml:"forDownstream,omitempty"`
+	ForUpstream     bool   `yaml:"forUpstream,omitempty"`
+	FallbackEnabled bool   `yaml:"fallbackEnabled,omitempty"`
+}
+
+// ZoneFilePath returns the location of the zonefile inside stateDir
+func (z ConfigAuthZone) ZoneFilePath(stateDir string) string {
+	if filepath.IsAbs(z.ZoneFile) {
+		return z.ZoneFile
+	}
+	file := z.ZoneFile
+	if file == "" {
+		name := strings.TrimSuffix(z.Name, ".")
+		if name == "" {
+			name = "root"
+		}
+		file = name + ".zone"
+	}
+	return filepath.Join(stateDir, file)
 }
 
 type ConfigZone struct {
-	Name    string   `yaml:"name"`
-	Servers []string `yaml:"servers"`
-	UseTCP  bool     `yaml:"useTCP"`
+	Name    string   `yaml:"name,omitempty"`
+	Servers []string `yaml:"servers,omitempty"`
+	UseTCP  bool     `yaml:"useTCP,omitempty"`
+	NoCache bool     `yaml:"noCache,omitempty"`
+	// ForwardFirst applies only to forward zones
+	ForwardFirst bool `yaml:"forwardFirst,omitempty"`
+	// StubPrime and StubFirst apply only to stub zones
+	StubPrime bool `yaml:"stubPrime,omitempty"`
+	StubFirst bool `yaml:"stubFirst,omitempty"`
 }
 
 func NewDefaultConfig() *Config {
@@ -58,11 +274,474 @@ func NewDefaultConfig() *Config {
 }
 
 func (c *Config) Validate() error {
+	if err := c.validateUpstreamServers(); err != nil {
+		return err
+	}
+
+	for _, z := range c.ForwardZones {
+		if z.StubPrime || z.StubFirst {
+			return fmt.Errorf("forward zone %q: stubPrime and stubFirst are only valid for stub zones", z.Name)
+		}
+	}
+	for _, z := range c.StubZones {
+		if z.ForwardFirst {
+			return fmt.Errorf("stub zone %q: forwardFirst is only valid for forward zones", z.Name)
+		}
+	}
+
+	if err := c.validateRateLimits(); err != nil {
+		return err
+	}
+
+	if err := c.validateAuthZones(); err != nil {
+		return err
+	}
+
+	if err := c.validateViews(); err != nil {
+		return err
+	}
+
+	if err := c.validateDNS64(); err != nil {
+		return err
+	}
+
+	if err := c.validateOverrides(); err != nil {
+		return err
+	}
+
+	for _, tld := range c.Kubernetes.SearchPathTLDs {
+		if tld == "" || strings.Contains(tld, ".") {
+			return fmt.Errorf("kubernetes: search path TLD %q must be a single label", tld)
+		}
+	}
+	if max := c.Kubernetes.SearchPathMaxLabels; max != 0 && max < MinSearchPathMaxLabels {
+		return fmt.Errorf("kubernetes: searchPathMaxLabels %d is below %d, which Service SRV names need", max, MinSearchPathMaxLabels)
+	}
+	if !c.Kubernetes.SearchPathOptimisation && (len(c.Kubernetes.SearchPathTLDs) > 0 || c.Kubernetes.SearchPathMaxLabels > 0) {
+		return fmt.Errorf("kubernetes: searchPathTLDs and searchPathMaxLabels need searchPathOptimisation")
+	}
+	if strings.HasPrefix(c.Kubernetes.ClusterDomain, ".") || strings.Contains(c.Kubernetes.ClusterDomain, "..") {
+		return fmt.Errorf("kubernetes: invalid cluster domain %q", c.Kubernetes.ClusterDomain)
+	}
+
+	for _, cidr := range c.RebindingProtection.PrivateAddresses {
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("rebindingProtection: invalid private address %q", cidr)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateRateLimits() error {
+	if c.IPRateLimit < 0 {
+		return fmt.Errorf("ipRateLimit must not be negative")
+	}
+	factors := []struct {
+		name   string
+		factor *int
+	}{
+		{"ratelimitFactor", c.RateLimitFactor},
+		{"ipRateLimitFactor", c.IPRateLimitFactor},
+	}
+	for _, f := range factors {
+		if f.factor != nil && *f.factor < 0 {
+			return fmt.Errorf("%s must not be negative", f.name)
+		}
+	}
+	slabs := []struct {
+		name  string
+		slabs int
+	}{
+		{"ratelimitSlabs", c.RateLimitSlabs},
+		{"ipRateLimitSlabs", c.IPRateLimitSlabs},
+	}
+	for _, s := range slabs {
+		if s.slabs != 0 && (s.slabs < 0 || s.slabs&(s.slabs-1) != 0) {
+			return fmt.Errorf("%s must be a power of 2", s.name)
+		}
+	}
+	sizes := []struct {
+		name string
+		size string
+	}{
+		{"ratelimitSize", c.RateLimitSize},
+		{"ipRateLimitSize", c.IPRateLimitSize},
+	}
+	for _, s := range sizes {
+		if s.size != "" && !memorySizePattern.MatchString(s.size) {
+			return fmt.Errorf("%s %q is not a valid memory size", s.name, s.size)
+		}
+	}
+	domainLimits := []struct {
+		name   string
+		limits []ConfigDomainLimit
+	}{
+		{"ratelimitForDomain", c.RateLimitForDomain},
+		{"ratelimitBelowDomain", c.RateLimitBelowDomain},
+	}
+	for _, dl := range domainLimits {
+		for _, l := range dl.limits {
+			if l.Name == "" {
+				return fmt.Errorf("%s entry without a name", dl.name)
+			}
+			if l.Limit < 0 {
+				return fmt.Errorf("%s %q: limit must not be negative", dl.name, l.Name)
+			}
+		}
+	}
 
 	return nil
 }
 
 func (c *Config) validateUpstreamServers() error {
+	zones := []struct {
+		kind string
+		list []ConfigZone
+	}{
+		{"forward", c.ForwardZones},
+		{"stub", c.StubZones},
+	}
+	for _, zl := range zones {
+		kind := zl.kind
+		seen := make(map[string]bool)
+		for _, z := range zl.list {
+			if z.Name == "" {
+				return fmt.Errorf("%s zone without a name", kind)
+			}
+			if seen[z.Name] {
+				return fmt.Errorf("duplicate %s zone %q", kind, z.Name)
+			}
+			seen[z.Name] = true
+
+			if len(z.Servers) == 0 {
+				return fmt.Errorf("%s zone %q has no servers", kind, z.Name)
+			}
+			for _, server := range z.Servers {
+				if err := validateServerAddr(server); err != nil {
+					return fmt.Errorf("%s zone %q: %v", kind, z.Name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateServerAddr checks an upstream in the form unbound accepts for
+// forward-addr and stub-addr: IP[@port][#tls-name]
+func validateServerAddr(server string) error {
+	addr := server
+	if i := strings.Index(addr, "#"); i >= 0 {
+		addr = addr[:i]
+	}
+	if i := strings.Index(addr, "@"); i >= 0 {
+		port, err := strconv.Atoi(addr[i+1:])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port in server address %q", server)
+		}
+		addr = addr[:i]
+	}
+	if net.ParseIP(addr) == nil {
+		return fmt.Errorf("invalid server address %q", server)
+	}
+	return nil
+}
+
+func (c *Config) validateAuthZones() error {
+	seen := make(map[string]bool)
+	for _, z := range c.AuthZones {
+		if z.Name == "" {
+			return fmt.Errorf("auth zone without a name")
+		}
+		if seen[z.Name] {
+			return fmt.Errorf("duplicate auth zone %q", z.Name)
+		}
+		seen[z.Name] = true
+
+		if len(z.Primaries) == 0 && len(z.URLs) == 0 && z.ZoneFile == "" {
+			return fmt.Errorf("auth zone %q needs primaries, urls or a zoneFile", z.Name)
+		}
+		if !z.ForDownstream && !z.ForUpstream {
+			return fmt.Errorf("auth zone %q is not used, enable forDownstream or forUpstream", z.Name)
+		}
+		for _, primary := range z.Primaries {
+			if err := validateServerAddr(primary); err != nil {
+				return fmt.Errorf("auth zone %q: %v", z.Name, err)
+			}
+		}
+		for _, u := range z.URLs {
+			parsed, err := url.Parse(u)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("auth zone %q: invalid url %q", z.Name, u)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateViews() error {
+	views := make(map[string]bool)
+	for _, v := range c.Views {
+		if v.Name == "" {
+			return fmt.Errorf("view without a name")
+		}
+		if views[v.Name] {
+			return fmt.Errorf("duplicate view %q", v.Name)
+		}
+		views[v.Name] = true
+
+		for _, lz := range v.LocalZones {
+			if lz.Name == "" {
+				return fmt.Errorf("view %q: local zone without a name", v.Name)
+			}
+			if !localZoneTypes[lz.Type] {
+				return fmt.Errorf("view %q: local zone %q has unknown type %q", v.Name, lz.Name, lz.Type)
+			}
+		}
+		for _, data := range v.LocalData {
+			if strings.TrimSpace(data) == "" {
+				return fmt.Errorf("view %q: empty local data", v.Name)
+			}
+		}
+		if len(v.ForwardZones) > 0 {
+			return fmt.Errorf("view %q: forward zones per view are not supported by unbound, use local zones and local data", v.Name)
+		}
+	}
+
+	for _, vc := range c.ViewClients {
+		if _, _, err := net.ParseCIDR(vc.CIDR); err != nil && net.ParseIP(vc.CIDR) == nil {
+			return fmt.Errorf("viewClients: invalid netblock %q", vc.CIDR)
+		}
+		if !views[vc.View] {
+			return fmt.Errorf("viewClients: %s references unknown view %q", vc.CIDR, vc.View)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateDNS64() error {
+	if !c.DNS64.Enabled || c.DNS64.Prefix == "" {
+		return nil
+	}
+	ip, prefix, err := net.ParseCIDR(c.DNS64.Prefix)
+	if err != nil || ip.To4() != nil {
+		return fmt.Errorf("dns64: prefix %q is not an IPv6 netblock", c.DNS64.Prefix)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return fmt.Errorf("dns64: prefix length /%d is not one of /32, /40, /48, /56, /64 or /96", ones)
+	}
+	return nil
+}
+
+// ModuleConfig returns the module-config value for the enabled features.
+// python answers before the other modules, dns64 has to come before the
+// validator, the iterator is always last.
+func (c *Config) ModuleConfig() string {
+	modules := make([]string, 0, 4)
+	if c.SearchPathScriptPath() != "" {
+		modules = append(modules, "python")
+	}
+	if c.DNS64.Enabled {
+		modules = append(modules, "dns64")
+	}
+	if c.DNSSEC.Enabled {
+		modules = append(modules, "validator")
+	}
+	modules = append(modules, "iterator")
+	return strings.Join(modules, " ")
+}
+
+// IPv6 returns true if unbound listens on IPv6 addresses
+func (c *Config) IPv6() bool {
+	for _, ip := range c.Interfaces {
+		if ip.To4() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateOverrides() error {
+	seen := make(map[string]bool)
+	for _, o := range c.Overrides {
+		if o.Name == "" {
+			return fmt.Errorf("override without a name")
+		}
+		if seen[o.Name] {
+			return fmt.Errorf("duplicate override %q", o.Name)
+		}
+		seen[o.Name] = true
 
+		if _, err := labels.Parse(o.NodeSelector); err != nil {
+			return fmt.Errorf("override %q: invalid node selector: %v", o.Name, err)
+		}
+		if o.NumThreads < 0 {
+			return fmt.Errorf("override %q: numThreads must not be negative", o.Name)
+		}
+		zones := &Config{ForwardZones: o.ForwardZones}
+		if err := zones.validateUpstreamServers(); err != nil {
+			return fmt.Errorf("override %q: %v", o.Name, err)
+		}
+	}
+	return nil
+}
+
+// ForNode returns a copy of the configuration with the override selected by the
+// node annotation or labels applied, together with the name of the override.
+// The configuration is returned unchanged when no override matches.
+func (c *Config) ForNode(nodeLabels, nodeAnnotations map[string]string) (*Config, string) {
+	override := c.selectOverride(nodeLabels, nodeAnnotations)
+	if override == nil {
+		return c, ""
+	}
+
+	cfg := *c
+	if override.NumThreads > 0 {
+		cfg.NumThreads = override.NumThreads
+	}
+	if len(override.ForwardZones) > 0 {
+		cfg.ForwardZones = make([]ConfigZone, 0, len(c.ForwardZones)+len(override.ForwardZones))
+		replaced := make(map[string]bool)
+		for _, z := range override.ForwardZones {
+			replaced[z.Name] = true
+		}
+		for _, z := range c.ForwardZones {
+			if !replaced[z.Name] {
+				cfg.ForwardZones = append(cfg.ForwardZones, z)
+			}
+		}
+		cfg.ForwardZones = append(cfg.ForwardZones, override.ForwardZones...)
+	}
+	return &cfg, override.Name
+}
+
+func (c *Config) selectOverride(nodeLabels, nodeAnnotations map[string]string) *ConfigOverride {
+	if name, ok := nodeAnnotations[OverrideAnnotation]; ok {
+		for i := range c.Overrides {
+			if c.Overrides[i].Name == name {
+				return &c.Overrides[i]
+			}
+		}
+		klog.Warningf("Node selects unknown override %q", name)
+	}
+	for i := range c.Overrides {
+		// an empty selector would match every node, such overrides are selected by annotation only
+		if c.Overrides[i].NodeSelector == "" {
+			continue
+		}
+		selector, err := labels.Parse(c.Overrides[i].NodeSelector)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(nodeLabels)) {
+			return &c.Overrides[i]
+		}
+	}
 	return nil
 }
+
+// ClusterDomain returns the kubernetes cluster domain without the trailing dot
+func (c *Config) ClusterDomain() string {
+	if c.Kubernetes.ClusterDomain == "" {
+		return DefaultClusterDomain
+	}
+	return strings.TrimSuffix(c.Kubernetes.ClusterDomain, ".")
+}
+
+// SearchPathZones returns the local zones answered with NXDOMAIN when
+// the search path optimisation is enabled
+func (c *Config) SearchPathZones() []string {
+	if !c.Kubernetes.SearchPathOptimisation {
+		return nil
+	}
+	tlds := c.Kubernetes.SearchPathTLDs
+	domain := c.ClusterDomain()
+	zones := make([]string, 0, 2*len(tlds))
+	for _, tld := range tlds {
+		// <name>.<tld>.svc.<domain> from the "svc.<domain>" search suffix
+		zones = append(zones, fmt.Sprintf("%s.svc.%s.", tld, domain))
+		// <name>.<tld>.<domain> from the "<domain>" search suffix
+		zones = append(zones, fmt.Sprintf("%s.%s.", tld, domain))
+	}
+	return zones
+}
+
+// ClusterReverseZones are forwarded to the cluster DNS together with the cluster domain
+var ClusterReverseZones = []string{"in-addr.arpa.", "ip6.arpa."}
+
+// WithClusterZones returns a copy of the configuration which forwards the
+// cluster domain and the reverse zones to servers. Zones already present
+// in the configuration are left untouched.
+func (c *Config) WithClusterZones(servers []string) *Config {
+	cfg := *c
+	if len(servers) == 0 {
+		return &cfg
+	}
+	cfg.ForwardZones = append([]ConfigZone{}, c.ForwardZones...)
+
+	zones := append([]string{c.ClusterDomain() + "."}, ClusterReverseZones...)
+	for _, name := range zones {
+		if c.hasZone(name) {
+			continue
+		}
+		cfg.ForwardZones = append(cfg.ForwardZones, ConfigZone{Name: name, Servers: servers})
+	}
+	cfg.UnblockLANZones = true
+	return &cfg
+}
+
+// WithDefaultUpstreams returns a copy of the configuration which forwards the
+// root zone to servers, unless a root forward, stub or auth zone is configured
+func (c *Config) WithDefaultUpstreams(servers []string) *Config {
+	cfg := *c
+	if len(servers) == 0 || c.hasZone(".") {
+		return &cfg
+	}
+	for _, z := range c.AuthZones {
+		if strings.TrimSuffix(z.Name, ".") == "" {
+			return &cfg
+		}
+	}
+	cfg.ForwardZones = append([]ConfigZone{}, c.ForwardZones...)
+	cfg.ForwardZones = append(cfg.ForwardZones, ConfigZone{Name: ".", Servers: servers})
+	return &cfg
+}
+
+// hasZone returns true if a forward or stub zone with the name is configured
+func (c *Config) hasZone(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	for _, zones := range [][]ConfigZone{c.ForwardZones, c.StubZones} {
+		for _, z := range zones {
+			if strings.TrimSuffix(z.Name, ".") == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PrivateAddresses returns the netblocks which are not allowed in upstream answers
+func (c *Config) PrivateAddresses() []string {
+	if len(c.RebindingProtection.PrivateAddresses) > 0 {
+		return c.RebindingProtection.PrivateAddresses
+	}
+	return DefaultPrivateAddresses
+}
+
+// PrivateDomains returns the domains allowed to resolve to private addresses.
+// Stub and forward zones (except the root) point to our own servers, so they are always included.
+func (c *Config) PrivateDomains() []string {
+	domains := append([]string{}, c.RebindingProtection.PrivateDomains...)
+	for _, z := range c.StubZones {
+		domains = append(domains, z.Name)
+	}
+	for _, z := range c.ForwardZones {
+		if z.Name != "." {
+			domains = append(domains, z.Name)
+		}
+	}
+	return domains
+}
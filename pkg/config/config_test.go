@@ -0,0 +1,136 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	intPtr := func(i int) *int { return &i }
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// wantErr is a part of the error, empty when the configuration is valid
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		// forward and stub zones
+		{"forward zone", func(c *Config) {
+			c.ForwardZones = []ConfigZone{{Name: ".", Servers: []string{"192.0.2.1", "2001:db8::1@5353", "192.0.2.2@853#dns.example.com"}, ForwardFirst: true}}
+		}, ""},
+		{"stub zone", func(c *Config) {
+			c.StubZones = []ConfigZone{{Name: "corp.example.", Servers: []string{"192.0.2.1"}, StubPrime: true, StubFirst: true, NoCache: true}}
+		}, ""},
+		{"zone without a name", func(c *Config) { c.ForwardZones = []ConfigZone{{Servers: []string{"192.0.2.1"}}} }, "forward zone without a name"},
+		{"duplicate zone", func(c *Config) {
+			c.StubZones = []ConfigZone{{Name: "a.", Servers: []string{"192.0.2.1"}}, {Name: "a.", Servers: []string{"192.0.2.2"}}}
+		}, `duplicate stub zone "a."`},
+		{"zone without servers", func(c *Config) { c.ForwardZones = []ConfigZone{{Name: "."}} }, "has no servers"},
+		{"server name", func(c *Config) { c.ForwardZones = []ConfigZone{{Name: ".", Servers: []string{"dns.example.com"}}} }, "invalid server address"},
+		{"server port", func(c *Config) { c.ForwardZones = []ConfigZone{{Name: ".", Servers: []string{"192.0.2.1@70000"}}} }, "invalid port"},
+		{"stub option on a forward zone", func(c *Config) {
+			c.ForwardZones = []ConfigZone{{Name: ".", Servers: []string{"192.0.2.1"}, StubFirst: true}}
+		}, "only valid for stub zones"},
+		{"forward option on a stub zone", func(c *Config) {
+			c.StubZones = []ConfigZone{{Name: "a.", Servers: []string{"192.0.2.1"}, ForwardFirst: true}}
+		}, "only valid for forward zones"},
+		// rate limits
+		{"rate limits", func(c *Config) {
+			c.IPRateLimit = 100
+			c.RateLimitFactor = intPtr(10)
+			c.RateLimitSlabs = 4
+			c.IPRateLimitSize = "4m"
+			c.RateLimitForDomain = []ConfigDomainLimit{{Name: "example.com.", Limit: 50}}
+		}, ""},
+		{"negative ip rate limit", func(c *Config) { c.IPRateLimit = -1 }, "ipRateLimit must not be negative"},
+		{"negative factor", func(c *Config) { c.IPRateLimitFactor = intPtr(-1) }, "ipRateLimitFactor must not be negative"},
+		{"first negative factor", func(c *Config) {
+			c.RateLimitFactor = intPtr(-1)
+			c.IPRateLimitFactor = intPtr(-1)
+		}, "ratelimitFactor must not be negative"},
+		{"slabs", func(c *Config) { c.RateLimitSlabs = 3 }, "ratelimitSlabs must be a power of 2"},
+		{"first invalid slabs", func(c *Config) {
+			c.RateLimitSlabs = 3
+			c.IPRateLimitSlabs = 5
+		}, "ratelimitSlabs must be a power of 2"},
+		{"size", func(c *Config) { c.RateLimitSize = "4 MB" }, "not a valid memory size"},
+		{"domain limit without a name", func(c *Config) { c.RateLimitBelowDomain = []ConfigDomainLimit{{Limit: 10}} }, "ratelimitBelowDomain entry without a name"},
+		{"negative domain limit", func(c *Config) {
+			c.RateLimitForDomain = []ConfigDomainLimit{{Name: "example.com.", Limit: -1}}
+		}, "limit must not be negative"},
+		// rebinding protection
+		{"private addresses", func(c *Config) {
+			c.RebindingProtection = ConfigRebindingProtection{Enabled: true, PrivateAddresses: DefaultPrivateAddresses}
+		}, ""},
+		{"private address", func(c *Config) {
+			c.RebindingProtection.PrivateAddresses = []string{"192.0.2.1", "fc00::/7"}
+		}, ""},
+		{"invalid private address", func(c *Config) { c.RebindingProtection.PrivateAddresses = []string{"10.0.0.0/33"} }, "invalid private address"},
+		// auth zones
+		{"auth zone", func(c *Config) {
+			c.AuthZones = []ConfigAuthZone{{Name: "example.com.", Primaries: []string{"192.0.2.1"}, URLs: []string{"https://example.com/zone"}, ForDownstream: true}}
+		}, ""},
+		{"auth zone without a name", func(c *Config) { c.AuthZones = []ConfigAuthZone{{ZoneFile: "a.zone", ForUpstream: true}} }, "auth zone without a name"},
+		{"duplicate auth zone", func(c *Config) {
+			c.AuthZones = []ConfigAuthZone{{Name: "a.", ZoneFile: "a.zone", ForUpstream: true}, {Name: "a.", ZoneFile: "a.zone", ForUpstream: true}}
+		}, `duplicate auth zone "a."`},
+		{"auth zone without a source", func(c *Config) { c.AuthZones = []ConfigAuthZone{{Name: "a.", ForUpstream: true}} }, "needs primaries, urls or a zoneFile"},
+		{"unused auth zone", func(c *Config) { c.AuthZones = []ConfigAuthZone{{Name: "a.", ZoneFile: "a.zone"}} }, "is not used"},
+		{"auth zone primary", func(c *Config) {
+			c.AuthZones = []ConfigAuthZone{{Name: "a.", Primaries: []string{"primary.example.com"}, ForUpstream: true}}
+		}, "invalid server address"},
+		{"auth zone url", func(c *Config) {
+			c.AuthZones = []ConfigAuthZone{{Name: "a.", URLs: []string{"ftp://example.com/zone"}, ForUpstream: true}}
+		}, "invalid url"},
+		// views
+		{"view", func(c *Config) {
+			c.Views = []ConfigView{{Name: "sandbox", LocalZones: []ConfigLocalZone{{Name: "internal.", Type: "static"}}, LocalData: []string{"metadata.internal. A 127.0.0.1"}}}
+			c.ViewClients = []ConfigViewClient{{CIDR: "10.200.0.0/16", View: "sandbox"}, {CIDR: "192.0.2.1", View: "sandbox"}}
+		}, ""},
+		{"view without a name", func(c *Config) { c.Views = []ConfigView{{}} }, "view without a name"},
+		{"duplicate view", func(c *Config) { c.Views = []ConfigView{{Name: "a"}, {Name: "a"}} }, `duplicate view "a"`},
+		{"local zone type", func(c *Config) {
+			c.Views = []ConfigView{{Name: "a", LocalZones: []ConfigLocalZone{{Name: "internal.", Type: "blocked"}}}}
+		}, `unknown type "blocked"`},
+		{"empty local data", func(c *Config) { c.Views = []ConfigView{{Name: "a", LocalData: []string{" "}}} }, "empty local data"},
+		{"view forward zones", func(c *Config) {
+			c.Views = []ConfigView{{Name: "a", ForwardZones: []ConfigZone{{Name: "example.com.", Servers: []string{"10.0.0.2"}}}}}
+		}, "forward zones per view are not supported"},
+		{"view client netblock", func(c *Config) {
+			c.Views = []ConfigView{{Name: "a"}}
+			c.ViewClients = []ConfigViewClient{{CIDR: "10.200.0.0/40", View: "a"}}
+		}, "invalid netblock"},
+		{"unknown view", func(c *Config) { c.ViewClients = []ConfigViewClient{{CIDR: "10.200.0.0/16", View: "a"}} }, `unknown view "a"`},
+		// dns64
+		{"dns64 well-known prefix", func(c *Config) { c.DNS64 = ConfigDNS64{Enabled: true} }, ""},
+		{"dns64 prefix", func(c *Config) { c.DNS64 = ConfigDNS64{Enabled: true, Prefix: "2001:db8:64::/96"} }, ""},
+		{"dns64 IPv4 prefix", func(c *Config) { c.DNS64 = ConfigDNS64{Enabled: true, Prefix: "192.0.2.0/24"} }, "is not an IPv6 netblock"},
+		{"dns64 prefix length", func(c *Config) { c.DNS64 = ConfigDNS64{Enabled: true, Prefix: "2001:db8::/80"} }, "prefix length /80"},
+		{"dns64 disabled", func(c *Config) { c.DNS64 = ConfigDNS64{Prefix: "192.0.2.0/24"} }, ""},
+		// node overrides
+		{"override", func(c *Config) {
+			c.Overrides = []ConfigOverride{{Name: "gpu", NodeSelector: "pool=gpu,zone in (a,b)", NumThreads: 4,
+				ForwardZones: []ConfigZone{{Name: ".", Servers: []string{"192.0.2.1"}}}}}
+		}, ""},
+		{"override without a name", func(c *Config) { c.Overrides = []ConfigOverride{{NodeSelector: "pool=gpu"}} }, "override without a name"},
+		{"duplicate override", func(c *Config) { c.Overrides = []ConfigOverride{{Name: "a"}, {Name: "a"}} }, `duplicate override "a"`},
+		{"override selector", func(c *Config) { c.Overrides = []ConfigOverride{{Name: "a", NodeSelector: "pool in gpu"}} }, "invalid node selector"},
+		{"override threads", func(c *Config) { c.Overrides = []ConfigOverride{{Name: "a", NumThreads: -1}} }, "numThreads must not be negative"},
+		{"override zones", func(c *Config) { c.Overrides = []ConfigOverride{{Name: "a", ForwardZones: []ConfigZone{{Name: "."}}}} }, `override "a": forward zone "." has no servers`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			tt.modify(c)
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() error = %v", err)
+			case tt.wantErr != "" && err == nil:
+				t.Errorf("Validate() accepted the configuration, want %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Errorf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// ConfigMapSource watches a ConfigMap through the kubernetes API and keeps
+// a copy of the last seen data in cacheFile, used while the API is unavailable
+type ConfigMapSource struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	key       string
+	cacheFile string
+
+	lock      sync.RWMutex
+	configMap *v1.ConfigMap
+	changes   chan struct{}
+	synced    cache.InformerSynced
+}
+
+// cachedConfigMap is the content of the cache file
+type cachedConfigMap struct {
+	ResourceVersion string `json:"resourceVersion"`
+	Data            string `json:"data"`
+}
+
+func NewConfigMapSource(client kubernetes.Interface, namespace, name, key, cacheFile string) *ConfigMapSource {
+	return &ConfigMapSource{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		key:       key,
+		cacheFile: cacheFile,
+		changes:   make(chan struct{}, 1),
+	}
+}
+
+func (s *ConfigMapSource) String() string {
+	return fmt.Sprintf("configmap %s/%s[%s]", s.namespace, s.name, s.key)
+}
+
+// Start watches the ConfigMap until stopCh is closed
+func (s *ConfigMapSource) Start(stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, 0,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { s.set(obj) },
+		UpdateFunc: func(_, obj interface{}) { s.set(obj) },
+		DeleteFunc: func(obj interface{}) {
+			klog.Warningf("%s was deleted, keeping the cached copy", s)
+		},
+	})
+	s.synced = informer.HasSynced
+	factory.Start(stopCh)
+}
+
+// WaitForSync blocks until the ConfigMap is fetched or the timeout expires
+func (s *ConfigMapSource) WaitForSync(timeout time.Duration) bool {
+	stopCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stopCh) })
+	defer timer.Stop()
+	return cache.WaitForCacheSync(stopCh, s.synced)
+}
+
+// Changes is notified every time the ConfigMap is updated
+func (s *ConfigMapSource) Changes() <-chan struct{} {
+	return s.changes
+}
+
+func (s *ConfigMapSource) set(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok || cm.Name != s.name {
+		return
+	}
+	s.lock.Lock()
+	s.configMap = cm
+	s.lock.Unlock()
+
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ConfigMapSource) load() (syncResult, error) {
+	s.lock.RLock()
+	cm := s.configMap
+	s.lock.RUnlock()
+
+	var cached cachedConfigMap
+	if cm != nil {
+		cached = cachedConfigMap{ResourceVersion: cm.ResourceVersion, Data: cm.Data[s.key]}
+		if err := s.writeCache(cached); err != nil {
+			klog.Warningf("Unable to cache %s: %v", s, err)
+		}
+	} else {
+		data, err := os.ReadFile(s.cacheFile)
+		if err != nil {
+			return syncResult{}, fmt.Errorf("%s is not available and there is no cached copy: %v", s, err)
+		}
+		if err := json.Unmarshal(data, &cached); err != nil {
+			return syncResult{}, fmt.Errorf("invalid cached copy of %s: %v", s, err)
+		}
+		klog.V(2).Infof("%s is not available, using cached resourceVersion %s", s, cached.ResourceVersion)
+	}
+
+	if !utf8.ValidString(cached.Data) {
+		return syncResult{}, fmt.Errorf("non-utf8 data in %s", s)
+	}
+
+	// compute a version string the same way as for the file source
+	version := ""
+	if len(cached.Data) > 0 {
+		hasher := sha256.New()
+		hasher.Write([]byte(s.key))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(cached.Data))
+		hasher.Write([]byte{0})
+		version = fmt.Sprintf("%x", hasher.Sum(nil))
+	}
+	return syncResult{
+		Version:         version,
+		ConfigData:      []byte(cached.Data),
+		Source:          s.String(),
+		ResourceVersion: cached.ResourceVersion,
+	}, nil
+}
+
+func (s *ConfigMapSource) writeCache(cached cachedConfigMap) error {
+	if s.cacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(s.cacheFile); err == nil && string(current) == string(data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cacheFile), 0755); err != nil {
+		return err
+	}
+	// write and rename, so a crash never leaves a partial copy behind
+	tmp := s.cacheFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cacheFile)
+}
@@ -0,0 +1,532 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CorefileImport is a Config translated from a CoreDNS Corefile of the
+// upstream node-local-dns, together with the settings which map to
+// node-cache flags instead of the Config
+type CorefileImport struct {
+	Config         *Config
+	BindAddresses  []string
+	Port           int
+	HealthAddress  string
+	MetricsAddress string
+	// Warnings list everything which could not be translated
+	Warnings []string
+
+	placeholders map[string]bool
+}
+
+// pillarPattern matches the placeholders substituted in the upstream
+// node-local-dns manifests, like __PILLAR__CLUSTER__DNS__
+var pillarPattern = regexp.MustCompile(`__PILLAR__[A-Z0-9_]*__`)
+
+// corefileEnvPattern matches the {$VAR} environment references of a Corefile
+var corefileEnvPattern = regexp.MustCompile(`\{\$([A-Za-z0-9_]+)\}`)
+
+// ImportCorefile translates the forward, cache, bind, health, prometheus and
+// log plugins of a Corefile, the other plugins are reported as warnings
+func ImportCorefile(r io.Reader) (*CorefileImport, error) {
+	tokens, err := tokenizeCorefile(r)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := parseCorefile(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &CorefileImport{
+		Config:       &Config{},
+		placeholders: make(map[string]bool),
+	}
+	for _, s := range servers {
+		imp.importServer(s)
+	}
+	return imp, nil
+}
+
+// ExpandPlaceholders replaces the __PILLAR__ placeholders with values, or
+// with the environment variable named like the placeholder, and returns the
+// placeholders which are left unresolved, each once
+func ExpandPlaceholders(data []byte, values map[string]string) ([]byte, []string) {
+	var unresolved []string
+	seen := make(map[string]bool)
+	expanded := pillarPattern.ReplaceAllFunc(data, func(name []byte) []byte {
+		if value, ok := values[string(name)]; ok {
+			return []byte(value)
+		}
+		if value, ok := os.LookupEnv(string(name)); ok {
+			return []byte(value)
+		}
+		if !seen[string(name)] {
+			seen[string(name)] = true
+			unresolved = append(unresolved, string(name))
+		}
+		return name
+	})
+	return expanded, unresolved
+}
+
+func (imp *CorefileImport) warnf(line int, format string, args ...interface{}) {
+	imp.Warnings = append(imp.Warnings, fmt.Sprintf("line %d: ", line)+fmt.Sprintf(format, args...))
+}
+
+// placeholder reports a __PILLAR__ placeholder once, it is kept as is
+func (imp *CorefileImport) placeholder(line int, value string) bool {
+	name := pillarPattern.FindString(value)
+	if name == "" {
+		return false
+	}
+	if !imp.placeholders[name] {
+		imp.placeholders[name] = true
+		imp.warnf(line, "placeholder %s is kept and must be substituted before the configuration is applied", name)
+	}
+	return true
+}
+
+func (imp *CorefileImport) importServer(s corefileServer) {
+	if strings.HasPrefix(s.keys[0], "(") {
+		imp.warnf(s.line, "snippet %s is not supported, it must be translated by hand", s.keys[0])
+		return
+	}
+
+	var zones []string
+	for _, key := range s.keys {
+		zone, port, err := parseCorefileKey(key)
+		if err != nil {
+			imp.warnf(s.line, "%v, server block skipped", err)
+			return
+		}
+		if port != 0 {
+			if imp.Port == 0 {
+				imp.Port = port
+			} else if imp.Port != port {
+				imp.warnf(s.line, "server block %s listens on port %d, only port %d is used", key, port, imp.Port)
+			}
+		}
+		zones = append(zones, zone)
+	}
+
+	for _, d := range s.directives {
+		switch d.name {
+		case "forward":
+			imp.importForward(zones, d)
+		case "cache":
+			imp.importCache(d)
+		case "bind":
+			imp.importBind(d)
+		case "health":
+			imp.HealthAddress = ":8080"
+			if len(d.args) > 0 {
+				imp.HealthAddress = d.args[0]
+			}
+		case "prometheus":
+			imp.MetricsAddress = "localhost:9153"
+			if len(d.args) > 0 {
+				imp.MetricsAddress = d.args[0]
+			}
+		case "log":
+			imp.Config.Logging.Queries = true
+		case "errors", "reload":
+			// node-cache logs unbound errors and reloads on changes by itself
+		default:
+			imp.warnf(d.line, "plugin %q has no unbound equivalent and is ignored", d.name)
+		}
+	}
+}
+
+// parseCorefileKey splits a server block key like dns://cluster.local:53
+func parseCorefileKey(key string) (string, int, error) {
+	if i := strings.Index(key, "://"); i >= 0 {
+		if scheme := key[:i]; scheme != "dns" {
+			return "", 0, fmt.Errorf("%s: transport %s is not supported", key, scheme)
+		}
+		key = key[i+3:]
+	}
+	if strings.Contains(key, "/") {
+		return "", 0, fmt.Errorf("%s: reverse zones from a network are not supported", key)
+	}
+
+	zone, port := key, 0
+	if i := strings.LastIndex(key, ":"); i >= 0 {
+		p, err := strconv.Atoi(key[i+1:])
+		if err != nil || p <= 0 || p > 65535 {
+			return "", 0, fmt.Errorf("%s: invalid port", key)
+		}
+		zone, port = key[:i], p
+	}
+	if zone == "" {
+		zone = "."
+	}
+	return zone, port, nil
+}
+
+func (imp *CorefileImport) importForward(zones []string, d corefileDirective) {
+	if len(d.args) < 2 {
+		imp.warnf(d.line, "forward without upstreams is ignored")
+		return
+	}
+	if from := d.args[0]; from != "." {
+		zones = []string{from}
+	}
+
+	zone := ConfigZone{}
+	for _, to := range d.args[1:] {
+		if imp.placeholder(d.line, to) {
+			zone.Servers = append(zone.Servers, to)
+			continue
+		}
+		server, err := corefileUpstream(to)
+		if err != nil {
+			imp.warnf(d.line, "forward: %v", err)
+			continue
+		}
+		zone.Servers = append(zone.Servers, server)
+	}
+	for _, o := range d.block {
+		switch o.name {
+		case "force_tcp":
+			zone.UseTCP = true
+		case "prefer_udp":
+			// unbound uses UDP unless the zone is TCP only
+		default:
+			imp.warnf(o.line, "forward option %q has no unbound equivalent and is ignored", o.name)
+		}
+	}
+	if len(zone.Servers) == 0 {
+		imp.warnf(d.line, "forward without usable upstreams is ignored")
+		return
+	}
+
+	for _, name := range zones {
+		if imp.Config.hasZone(name) {
+			imp.warnf(d.line, "zone %q is already forwarded, keeping the first forward", name)
+			continue
+		}
+		z := zone
+		z.Name = name
+		imp.Config.ForwardZones = append(imp.Config.ForwardZones, z)
+	}
+}
+
+// corefileUpstream converts a forward upstream like dns://10.0.0.10:5353
+// to the IP[@port] form of unbound
+func corefileUpstream(to string) (string, error) {
+	if strings.HasPrefix(to, "/") {
+		return "", fmt.Errorf("upstreams from %s are not supported, list its nameservers instead", to)
+	}
+	if i := strings.Index(to, "://"); i >= 0 {
+		if scheme := to[:i]; scheme != "dns" {
+			return "", fmt.Errorf("upstream %s: transport %s is not supported", to, scheme)
+		}
+		to = to[i+3:]
+	}
+
+	host, port, err := net.SplitHostPort(to)
+	if err != nil {
+		host, port = to, "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("upstream %s is not an IP address", to)
+	}
+	if port == "53" {
+		return host, nil
+	}
+	server := host + "@" + port
+	if err := validateServerAddr(server); err != nil {
+		return "", err
+	}
+	return server, nil
+}
+
+// importCache maps the cache plugin onto the global unbound cache, the
+// capacities are left to the unbound defaults
+func (imp *CorefileImport) importCache(d corefileDirective) {
+	var cache ConfigCache
+	if len(d.args) > 0 {
+		ttl, err := strconv.Atoi(d.args[0])
+		zonesFrom := 1
+		if err != nil {
+			zonesFrom = 0
+		} else {
+			cache.MaxTTL = ttl
+			cache.NegativeMaxTTL = ttl
+		}
+		if len(d.args) > zonesFrom {
+			imp.warnf(d.line, "cache zones %v are ignored, the unbound cache is global", d.args[zonesFrom:])
+		}
+	}
+
+	for _, o := range d.block {
+		switch o.name {
+		case "success":
+			if len(o.args) > 1 {
+				cache.MaxTTL, _ = strconv.Atoi(o.args[1])
+			}
+			if len(o.args) > 2 {
+				cache.MinTTL, _ = strconv.Atoi(o.args[2])
+			}
+		case "denial":
+			if len(o.args) > 1 {
+				cache.NegativeMaxTTL, _ = strconv.Atoi(o.args[1])
+			}
+		case "prefetch":
+			cache.Prefetch = true
+		case "serve_stale":
+			cache.ServeExpired = true
+			cache.ServeExpiredTTL = 3600
+			if len(o.args) > 0 {
+				ttl, err := corefileDuration(o.args[0])
+				if err != nil {
+					imp.warnf(o.line, "serve_stale: %v", err)
+				} else {
+					cache.ServeExpiredTTL = ttl
+				}
+			}
+			if len(o.args) > 1 && o.args[1] == "verify" {
+				// the client timeout recommended by RFC 8767
+				cache.ServeExpiredClientTimeout = 1800
+			}
+		default:
+			imp.warnf(o.line, "cache option %q has no unbound equivalent and is ignored", o.name)
+		}
+	}
+
+	imp.mergeCache(d.line, cache)
+}
+
+// mergeCache combines the cache settings of all server blocks, the first
+// server block wins when they disagree
+func (imp *CorefileImport) mergeCache(line int, cache ConfigCache) {
+	current := &imp.Config.Cache
+	merge := func(name string, current *int, value int) {
+		switch {
+		case value == 0 || *current == value:
+		case *current == 0:
+			*current = value
+		default:
+			imp.warnf(line, "cache %s %d differs from %d of an earlier server block, the unbound cache is global so %d is kept",
+				name, value, *current, *current)
+		}
+	}
+	merge("maxTTL", &current.MaxTTL, cache.MaxTTL)
+	merge("minTTL", &current.MinTTL, cache.MinTTL)
+	merge("negativeMaxTTL", &current.NegativeMaxTTL, cache.NegativeMaxTTL)
+	merge("serveExpiredTTL", &current.ServeExpiredTTL, cache.ServeExpiredTTL)
+	merge("serveExpiredClientTimeout", &current.ServeExpiredClientTimeout, cache.ServeExpiredClientTimeout)
+	current.Prefetch = current.Prefetch || cache.Prefetch
+	current.ServeExpired = current.ServeExpired || cache.ServeExpired
+}
+
+// corefileDuration returns the seconds of a duration like 1h or 3600
+func corefileDuration(value string) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return int(d.Seconds()), nil
+}
+
+func (imp *CorefileImport) importBind(d corefileDirective) {
+	for _, addr := range d.args {
+		if net.ParseIP(addr) == nil && !imp.placeholder(d.line, addr) {
+			imp.warnf(d.line, "bind %s is not an IP address and is ignored", addr)
+			continue
+		}
+		known := false
+		for _, a := range imp.BindAddresses {
+			known = known || a == addr
+		}
+		if !known {
+			imp.BindAddresses = append(imp.BindAddresses, addr)
+		}
+	}
+	if len(d.block) > 0 {
+		imp.warnf(d.line, "bind options are ignored")
+	}
+}
+
+type corefileToken struct {
+	text string
+	line int
+}
+
+type corefileDirective struct {
+	name  string
+	args  []string
+	block []corefileDirective
+	line  int
+}
+
+type corefileServer struct {
+	keys       []string
+	directives []corefileDirective
+	line       int
+}
+
+// tokenizeCorefile splits a Corefile into words, dropping comments and
+// expanding {$VAR} environment references
+func tokenizeCorefile(r io.Reader) ([]corefileToken, error) {
+	var tokens []corefileToken
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := corefileEnvPattern.ReplaceAllStringFunc(scanner.Text(), func(ref string) string {
+			if value, ok := os.LookupEnv(corefileEnvPattern.FindStringSubmatch(ref)[1]); ok {
+				return value
+			}
+			return ref
+		})
+		words, err := splitCorefileLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("Corefile line %d: %v", line, err)
+		}
+		for _, w := range words {
+			tokens = append(tokens, corefileToken{text: w, line: line})
+		}
+	}
+	return tokens, scanner.Err()
+}
+
+func splitCorefileLine(text string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quoted bool
+	)
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case quoted && ch == '\\' && i+1 < len(text):
+			i++
+			word.WriteByte(text[i])
+		case quoted && ch == '"':
+			quoted = false
+		case quoted:
+			word.WriteByte(ch)
+		case ch == '"':
+			quoted, inWord = true, true
+		case ch == ' ' || ch == '\t' || ch == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case ch == '#' && !inWord:
+			return words, nil
+		default:
+			word.WriteByte(ch)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+type corefileParser struct {
+	tokens []corefileToken
+	pos    int
+}
+
+func (p *corefileParser) next() (corefileToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return corefileToken{}, false
+	}
+	p.pos++
+	return p.tokens[p.pos-1], true
+}
+
+// parseCorefile groups the tokens into server blocks of directives, a
+// directive spans the rest of its line and an optional block
+func parseCorefile(tokens []corefileToken) ([]corefileServer, error) {
+	p := &corefileParser{tokens: tokens}
+	var servers []corefileServer
+	for p.pos < len(p.tokens) {
+		server := corefileServer{line: p.tokens[p.pos].line}
+		for {
+			t, ok := p.next()
+			if !ok {
+				return nil, fmt.Errorf("Corefile line %d: server block without braces is not supported", server.line)
+			}
+			if t.text == "{" {
+				break
+			}
+			if t.text == "}" {
+				return nil, fmt.Errorf("Corefile line %d: unexpected }", t.line)
+			}
+			for _, key := range strings.Split(t.text, ",") {
+				if key != "" {
+					server.keys = append(server.keys, key)
+				}
+			}
+		}
+		if len(server.keys) == 0 {
+			return nil, fmt.Errorf("Corefile line %d: server block without keys", server.line)
+		}
+
+		directives, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		server.directives = directives
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// block parses directives up to and including the closing brace
+func (p *corefileParser) block() ([]corefileDirective, error) {
+	var directives []corefileDirective
+	for {
+		t, ok := p.next()
+		if !ok {
+			return nil, fmt.Errorf("Corefile: missing }")
+		}
+		if t.text == "}" {
+			return directives, nil
+		}
+		if t.text == "{" {
+			return nil, fmt.Errorf("Corefile line %d: unexpected {", t.line)
+		}
+
+		d := corefileDirective{name: t.text, line: t.line}
+		for p.pos < len(p.tokens) && p.tokens[p.pos].line == t.line {
+			arg := p.tokens[p.pos]
+			if arg.text == "}" {
+				break
+			}
+			p.pos++
+			if arg.text == "{" {
+				block, err := p.block()
+				if err != nil {
+					return nil, err
+				}
+				d.block = block
+				break
+			}
+			d.args = append(d.args, arg.text)
+		}
+		directives = append(directives, d)
+	}
+}
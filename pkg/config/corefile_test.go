@@ -0,0 +1,164 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// nodeLocalDNSCorefile is the Corefile of the node-local-dns manifest
+const nodeLocalDNSCorefile = `cluster.local:53 {
+    errors
+    cache {
+            success 9984 30
+            denial 9984 5
+    }
+    reload
+    loop
+    bind __PILLAR__LOCAL__DNS__ __PILLAR__DNS__SERVER__
+    forward . __PILLAR__CLUSTER__DNS__ {
+            force_tcp
+    }
+    prometheus :9253
+    health __PILLAR__LOCAL__DNS__:8080
+    }
+in-addr.arpa:53 {
+    errors
+    cache 30
+    reload
+    loop
+    bind __PILLAR__LOCAL__DNS__ __PILLAR__DNS__SERVER__
+    forward . __PILLAR__CLUSTER__DNS__ {
+            force_tcp
+    }
+    prometheus :9253
+    }
+.:53 {
+    errors
+    cache 30
+    reload
+    loop
+    bind __PILLAR__LOCAL__DNS__ __PILLAR__DNS__SERVER__
+    forward . __PILLAR__UPSTREAM__SERVERS__
+    prometheus :9253
+    }
+`
+
+func TestExpandPlaceholders(t *testing.T) {
+	t.Setenv("__PILLAR__FROM__ENV__", "192.0.2.9")
+	tests := []struct {
+		name           string
+		data           string
+		values         map[string]string
+		want           string
+		wantUnresolved []string
+	}{
+		{
+			name: "no placeholders",
+			data: "forward . 10.0.0.2",
+			want: "forward . 10.0.0.2",
+		},
+		{
+			name:   "values",
+			data:   "bind __PILLAR__LOCAL__DNS__ __PILLAR__DNS__SERVER__",
+			values: map[string]string{"__PILLAR__LOCAL__DNS__": "169.254.20.10", "__PILLAR__DNS__SERVER__": "10.96.0.10"},
+			want:   "bind 169.254.20.10 10.96.0.10",
+		},
+		{
+			name: "environment",
+			data: "forward . __PILLAR__FROM__ENV__",
+			want: "forward . 192.0.2.9",
+		},
+		{
+			name:           "unresolved once",
+			data:           "bind __PILLAR__LOCAL__DNS__\nforward . __PILLAR__CLUSTER__DNS__\nhealth __PILLAR__LOCAL__DNS__:8080",
+			values:         map[string]string{},
+			want:           "bind __PILLAR__LOCAL__DNS__\nforward . __PILLAR__CLUSTER__DNS__\nhealth __PILLAR__LOCAL__DNS__:8080",
+			wantUnresolved: []string{"__PILLAR__LOCAL__DNS__", "__PILLAR__CLUSTER__DNS__"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unresolved := ExpandPlaceholders([]byte(tt.data), tt.values)
+			if string(got) != tt.want {
+				t.Errorf("ExpandPlaceholders() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(unresolved, tt.wantUnresolved) {
+				t.Errorf("ExpandPlaceholders() unresolved = %v, want %v", unresolved, tt.wantUnresolved)
+			}
+		})
+	}
+}
+
+func TestImportCorefile(t *testing.T) {
+	values := map[string]string{
+		"__PILLAR__LOCAL__DNS__":        "169.254.20.10",
+		"__PILLAR__DNS__SERVER__":       "10.96.0.10",
+		"__PILLAR__CLUSTER__DNS__":      "10.96.0.11",
+		"__PILLAR__UPSTREAM__SERVERS__": "10.0.0.2 10.0.0.3:5353",
+	}
+	expanded, unresolved := ExpandPlaceholders([]byte(nodeLocalDNSCorefile), values)
+	if len(unresolved) > 0 {
+		t.Fatalf("unresolved placeholders %v", unresolved)
+	}
+	imp, err := ImportCorefile(strings.NewReader(string(expanded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"169.254.20.10", "10.96.0.10"}; !reflect.DeepEqual(imp.BindAddresses, want) {
+		t.Errorf("BindAddresses = %v, want %v", imp.BindAddresses, want)
+	}
+	if imp.Port != 53 || imp.MetricsAddress != ":9253" || imp.HealthAddress != "169.254.20.10:8080" {
+		t.Errorf("Port %d, MetricsAddress %q, HealthAddress %q", imp.Port, imp.MetricsAddress, imp.HealthAddress)
+	}
+	wantZones := []ConfigZone{
+		{Name: "cluster.local", Servers: []string{"10.96.0.11"}, UseTCP: true},
+		{Name: "in-addr.arpa", Servers: []string{"10.96.0.11"}, UseTCP: true},
+		{Name: ".", Servers: []string{"10.0.0.2", "10.0.0.3@5353"}},
+	}
+	if len(imp.Config.ForwardZones) != len(wantZones) {
+		t.Fatalf("ForwardZones = %+v, want %+v", imp.Config.ForwardZones, wantZones)
+	}
+	for i, want := range wantZones {
+		got := imp.Config.ForwardZones[i]
+		if got.Name != want.Name || !reflect.DeepEqual(got.Servers, want.Servers) || got.UseTCP != want.UseTCP {
+			t.Errorf("ForwardZones[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if cache := imp.Config.Cache; cache.MaxTTL != 30 || cache.NegativeMaxTTL != 5 {
+		t.Errorf("Cache = %+v, want maxTTL 30 and negativeMaxTTL 5", cache)
+	}
+	// the loop plugin, and the denial TTL of the cluster domain kept for the global cache
+	for _, warning := range imp.Warnings {
+		if !strings.Contains(warning, `plugin "loop"`) && !strings.Contains(warning, "negativeMaxTTL 30 differs from 5") {
+			t.Errorf("unexpected warning %q", warning)
+		}
+	}
+}
+
+func TestImportCorefileWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		corefile string
+		want     string
+	}{
+		{"unsupported transport", "tls://.:853 {\n forward . 10.0.0.2\n}\n", "transport tls is not supported"},
+		{"upstream from a file", ".:53 {\n forward . /etc/resolv.conf\n}\n", "list its nameservers instead"},
+		{"upstream name", ".:53 {\n forward . dns.example.com\n}\n", "is not an IP address"},
+		{"kept placeholder", ".:53 {\n forward . __PILLAR__CLUSTER__DNS__\n}\n", "placeholder __PILLAR__CLUSTER__DNS__ is kept"},
+		{"second port", ".:53 {\n}\nexample.com:5353 {\n}\n", "only port 53 is used"},
+		{"duplicate zone", ".:53 {\n forward . 10.0.0.2\n}\n.:53 {\n forward . 10.0.0.3\n}\n", "already forwarded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imp, err := ImportCorefile(strings.NewReader(tt.corefile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(strings.Join(imp.Warnings, "\n"), tt.want) {
+				t.Errorf("Warnings = %v, want %q", imp.Warnings, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MinSearchPathMaxLabels allows the Service SRV names, _port._proto.<service>.<namespace>
+	MinSearchPathMaxLabels = 4
+	// searchPathScriptFile is written to the state directory
+	searchPathScriptFile = "searchpath.py"
+)
+
+// searchPathScript is an unbound python module answering NXDOMAIN for names
+// with more labels under the svc suffix than a Service name can have. Local
+// zones only match suffixes, so the labels cannot be counted by them.
+const searchPathScript = `# Generated by node-cache, answers NXDOMAIN for names with more than
+# %[2]d labels under %[1]s
+SUFFIX = "%[1]s"
+MAX_LABELS = %[2]d
+
+
+def init_standard(id, env):
+    return True
+
+
+def deinit(id):
+    return True
+
+
+def inform_super(id, qstate, superqstate, qdata):
+    return True
+
+
+def too_many_labels(name):
+    name = name.lower()
+    if not name.endswith("." + SUFFIX):
+        return False
+    return len(name[:-len(SUFFIX) - 1].split(".")) > MAX_LABELS
+
+
+def operate(id, event, qstate, qdata):
+    if event in (MODULE_EVENT_NEW, MODULE_EVENT_PASS):
+        if too_many_labels(qstate.qinfo.qname_str):
+            msg = DNSMessage(qstate.qinfo.qname_str, qstate.qinfo.qtype, qstate.qinfo.qclass, PKT_QR | PKT_RA | PKT_AA)
+            if not msg.set_return_msg(qstate):
+                qstate.ext_state[id] = MODULE_ERROR
+                return True
+            qstate.return_rcode = RCODE_NXDOMAIN
+            qstate.return_msg.rep.security = 2
+            # counted by node-cache like the log-local-actions lines
+            log_info("%%s always_nxdomain - %%s %%s %%s" %% (SUFFIX, qstate.qinfo.qname_str, qstate.qinfo.qtype_str, qstate.qinfo.qclass_str))
+            qstate.ext_state[id] = MODULE_FINISHED
+            return True
+        qstate.ext_state[id] = MODULE_WAIT_MODULE
+        return True
+    if event == MODULE_EVENT_MODDONE:
+        qstate.ext_state[id] = MODULE_FINISHED
+        return True
+    qstate.ext_state[id] = MODULE_ERROR
+    return True
+`
+
+// SearchPathScriptPath returns the path of the python module limiting the
+// labels under svc.<domain>, empty when the limit is disabled
+func (c *Config) SearchPathScriptPath() string {
+	if !c.Kubernetes.SearchPathOptimisation || c.Kubernetes.SearchPathMaxLabels <= 0 {
+		return ""
+	}
+	return filepath.Join(c.StateDir, searchPathScriptFile)
+}
+
+// SearchPathSuffix returns the zone the label limit applies to, svc.<domain>.
+func (c *Config) SearchPathSuffix() string {
+	return "svc." + c.ClusterDomain() + "."
+}
+
+// SearchPathScript returns the python module enforcing SearchPathMaxLabels
+func (c *Config) SearchPathScript() string {
+	return fmt.Sprintf(searchPathScript, c.SearchPathSuffix(), c.Kubernetes.SearchPathMaxLabels)
+}
+
+// TooManySearchPathLabels returns true for names the python module answers
+// with NXDOMAIN, it follows the same rule
+func (c *Config) TooManySearchPathLabels(name string) bool {
+	if c.SearchPathScriptPath() == "" {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, ".") + ".")
+	suffix := "." + c.SearchPathSuffix()
+	if !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	return len(strings.Split(strings.TrimSuffix(name, suffix), ".")) > c.Kubernetes.SearchPathMaxLabels
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSearchPathZones(t *testing.T) {
+	tests := []struct {
+		name       string
+		kubernetes ConfigKubernetes
+		want       []string
+	}{
+		{
+			name:       "disabled",
+			kubernetes: ConfigKubernetes{SearchPathTLDs: []string{"com"}},
+		},
+		{
+			name:       "no TLDs by default",
+			kubernetes: ConfigKubernetes{SearchPathOptimisation: true},
+			want:       []string{},
+		},
+		{
+			name:       "opt-in TLDs",
+			kubernetes: ConfigKubernetes{SearchPathOptimisation: true, SearchPathTLDs: []string{"com", "org"}},
+			want:       []string{"com.svc.cluster.local.", "com.cluster.local.", "org.svc.cluster.local.", "org.cluster.local."},
+		},
+		{
+			name:       "cluster domain",
+			kubernetes: ConfigKubernetes{SearchPathOptimisation: true, SearchPathTLDs: []string{"com"}, ClusterDomain: "example.internal."},
+			want:       []string{"com.svc.example.internal.", "com.example.internal."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			c.Kubernetes = tt.kubernetes
+			if got := c.SearchPathZones(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchPathZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSearchPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		kubernetes ConfigKubernetes
+		wantErr    bool
+	}{
+		{"disabled", ConfigKubernetes{}, false},
+		{"enabled", ConfigKubernetes{SearchPathOptimisation: true}, false},
+		{"TLDs", ConfigKubernetes{SearchPathOptimisation: true, SearchPathTLDs: []string{"com"}}, false},
+		{"TLD with a dot", ConfigKubernetes{SearchPathOptimisation: true, SearchPathTLDs: []string{"co.uk"}}, true},
+		{"empty TLD", ConfigKubernetes{SearchPathOptimisation: true, SearchPathTLDs: []string{""}}, true},
+		{"TLDs without optimisation", ConfigKubernetes{SearchPathTLDs: []string{"com"}}, true},
+		{"max labels", ConfigKubernetes{SearchPathOptimisation: true, SearchPathMaxLabels: 4}, false},
+		{"max labels below SRV names", ConfigKubernetes{SearchPathOptimisation: true, SearchPathMaxLabels: 3}, true},
+		{"max labels without optimisation", ConfigKubernetes{SearchPathMaxLabels: 4}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			c.Kubernetes = tt.kubernetes
+			if err := c.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTooManySearchPathLabels(t *testing.T) {
+	c := NewDefaultConfig()
+	c.StateDir = "/var/lib/unbound"
+	c.Kubernetes = ConfigKubernetes{SearchPathOptimisation: true, SearchPathMaxLabels: 4}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"kubernetes.default.svc.cluster.local.", false},
+		{"_https._tcp.kubernetes.default.svc.cluster.local.", false},
+		{"_https._tcp.kubernetes.default.svc.cluster.local", false},
+		{"example.com.default.svc.cluster.local.", false},
+		{"www.example.com.default.svc.cluster.local.", false},
+		{"www.example.co.uk.default.svc.cluster.local.", true},
+		{"WWW.Example.Co.UK.Default.SVC.Cluster.Local.", true},
+		{"a.b.c.d.e.cluster.local.", false},
+		{"a.b.c.d.e.example.com.", false},
+		{"svc.cluster.local.", false},
+	}
+	for _, tt := range tests {
+		if got := c.TooManySearchPathLabels(tt.name); got != tt.want {
+			t.Errorf("TooManySearchPathLabels(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	c.Kubernetes.SearchPathMaxLabels = 0
+	if c.TooManySearchPathLabels("a.b.c.d.e.svc.cluster.local.") {
+		t.Errorf("TooManySearchPathLabels() is true with the limit disabled")
+	}
+}
+
+func TestSearchPathScript(t *testing.T) {
+	c := NewDefaultConfig()
+	c.StateDir = "/var/lib/unbound"
+	if path := c.SearchPathScriptPath(); path != "" {
+		t.Errorf("SearchPathScriptPath() = %q with the limit disabled", path)
+	}
+	if modules := c.ModuleConfig(); strings.Contains(modules, "python") {
+		t.Errorf("ModuleConfig() = %q with the limit disabled", modules)
+	}
+
+	c.Kubernetes = ConfigKubernetes{SearchPathOptimisation: true, SearchPathMaxLabels: 5, ClusterDomain: "example.internal"}
+	if path := c.SearchPathScriptPath(); path != "/var/lib/unbound/searchpath.py" {
+		t.Errorf("SearchPathScriptPath() = %q", path)
+	}
+	if modules := c.ModuleConfig(); modules != "python iterator" {
+		t.Errorf("ModuleConfig() = %q, want python first", modules)
+	}
+	script := c.SearchPathScript()
+	for _, want := range []string{`SUFFIX = "svc.example.internal."`, "MAX_LABELS = 5", `log_info("%s always_nxdomain - %s %s %s" % (SUFFIX`} {
+		if !strings.Contains(script, want) {
+			t.Errorf("SearchPathScript() does not contain %q", want)
+		}
+	}
+	if strings.Contains(script, "%!") {
+		t.Errorf("SearchPathScript() has a formatting error:\n%s", script)
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
+	"github.com/hvoyvodov/nodelocaldns/pkg/metrics"
 	"gopkg.in/yaml.v2"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/clock"
@@ -22,12 +26,29 @@ type Sync struct {
 	latestVersion string
 	clock         clock.Clock
 	period        time.Duration
+	// loader reads the configuration data, from files unless another source is used
+	loader func() (syncResult, error)
+	// trigger requests a load before the period expires, nil when the source is polled only
+	trigger <-chan struct{}
+
+	lock   sync.RWMutex
+	status SyncStatus
 }
 
 type syncResult struct {
 	Version         string
 	AdditionalFiles []string
 	ConfigData      []byte
+	Source          string
+	ResourceVersion string
+}
+
+// SyncStatus reports which configuration is active
+type SyncStatus struct {
+	Source          string    `json:"source"`
+	Version         string    `json:"version"`
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	Updated         time.Time `json:"updated,omitempty"`
 }
 
 func NewSync(configFile string, filesDir string, period time.Duration) *Sync {
@@ -38,11 +59,62 @@ func NewSync(configFile string, filesDir string, period time.Duration) *Sync {
 		period:        period,
 		clock:         clock.RealClock{},
 	}
+	sync.loader = sync.load
 	return sync
 }
 
+// NewConfigMapSync returns a Sync which loads the configuration from a ConfigMap
+// watched through the kubernetes API instead of the mounted file
+func NewConfigMapSync(source *ConfigMapSource, period time.Duration) *Sync {
+	sync := &Sync{
+		configFile: source.String(),
+		channel:    make(chan *Config),
+		period:     period,
+		clock:      clock.RealClock{},
+		loader:     source.load,
+		trigger:    source.Changes(),
+	}
+	return sync
+}
+
+// Transform converts the loaded configuration data before it is versioned
+// and parsed, like a Corefile which is imported into the YAML configuration
+func (s *Sync) Transform(transform func([]byte) ([]byte, error)) {
+	load := s.loader
+	s.loader = func() (syncResult, error) {
+		result, err := load()
+		if err != nil || len(result.ConfigData) == 0 {
+			return result, err
+		}
+		data, err := transform(result.ConfigData)
+		if err != nil {
+			return syncResult{}, err
+		}
+		// the transformed data can change while the source stays the same
+		hasher := sha256.New()
+		hasher.Write([]byte(result.Version))
+		hasher.Write([]byte{0})
+		hasher.Write(data)
+		result.Version = fmt.Sprintf("%x", hasher.Sum(nil))
+		result.ConfigData = data
+		return result, nil
+	}
+}
+
+// Status reports the active configuration version and where it came from
+func (s *Sync) Status() interface{} {
+	return s.Current()
+}
+
+// Current returns the active configuration version and where it came from
+func (s *Sync) Current() SyncStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.status
+}
+
 func (s *Sync) Once() (*Config, error) {
-	result, err := s.load()
+	result, err := s.loader()
 	if err != nil {
 
 		return NewDefaultConfig(), err
@@ -56,7 +128,7 @@ func (s *Sync) Periodic() <-chan *Config {
 	go func() {
 		ticker := s.clock.Tick(s.period)
 		for {
-			if result, err := s.load(); err != nil {
+			if result, err := s.loader(); err != nil {
 				klog.Errorf("Error loading config from %s: %v", s.configFile, err)
 			} else {
 				config, changed, err := s.processUpdate(result, false)
@@ -64,7 +136,10 @@ func (s *Sync) Periodic() <-chan *Config {
 					s.channel <- config
 				}
 			}
-			<-ticker
+			select {
+			case <-ticker:
+			case <-s.trigger:
+			}
 		}
 	}()
 	return s.channel
@@ -142,7 +217,7 @@ func (s *Sync) load() (syncResult, error) {
 	if len(configData) > 0 || len(files) > 0 {
 		version = fmt.Sprintf("%x", hasher.Sum(nil))
 	}
-	return syncResult{Version: version, AdditionalFiles: files, ConfigData: configData}, nil
+	return syncResult{Version: version, AdditionalFiles: files, ConfigData: configData, Source: s.configFile}, nil
 }
 
 func (s *Sync) processUpdate(result syncResult, buildUnchangedConfig bool) (config *Config, changed bool, err error) {
@@ -152,7 +227,6 @@ func (s *Sync) processUpdate(result syncResult, buildUnchangedConfig bool) (conf
 		klog.V(3).Infof("Updating config to version %v (was %v)",
 			result.Version, s.latestVersion)
 		changed = true
-		s.latestVersion = result.Version
 	} else {
 		klog.V(4).Infof("Config was unchanged (version %v)", s.latestVersion)
 		// short-circuit if we haven't been asked to build an unchanged config object
@@ -166,16 +240,70 @@ func (s *Sync) processUpdate(result syncResult, buildUnchangedConfig bool) (conf
 	if result.Version == "" && len(result.ConfigData) == 0 {
 		config = NewDefaultConfig()
 		config.AdditionalFiles = result.AdditionalFiles
+		s.setStatus(result, changed)
 		return
 	}
 
 	if err = yaml.Unmarshal([]byte(result.ConfigData), &config); err != nil {
-		klog.Warning("Unable to parse configuration. Will continue with default. %v", err)
+		klog.Warningf("Unable to parse configuration. Will continue with default. %v", err)
+		events.Warning(events.ReasonConfigRejected, "Unable to parse configuration from %s: %v", result.Source, err)
 		config = NewDefaultConfig()
 		config.AdditionalFiles = result.AdditionalFiles
 		return
 	}
 	config.AdditionalFiles = result.AdditionalFiles
+	config.Version = result.Version
+	if keys := runtimeKeys(result.ConfigData); len(keys) > 0 {
+		klog.Warningf("Configuration keys %s are set by node-cache and ignored", strings.Join(keys, ", "))
+	}
 
+	if err = config.Validate(); err != nil {
+		klog.Warningf("Configuration version %v is invalid: %v", result.Version, err)
+		metrics.PublishErrorMetric("config")
+		events.Warning(events.ReasonConfigRejected, "Configuration from %s is rejected: %v", result.Source, err)
+		return
+	}
+
+	s.setStatus(result, changed)
 	return
 }
+
+// runtimeKeys returns the keys of the configuration data which name a Config
+// field set at runtime. Before these fields were excluded from YAML, they
+// were accepted under their lower case name and then overwritten.
+func runtimeKeys(data []byte) []string {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("yaml") != "-" {
+			continue
+		}
+		if _, ok := values[strings.ToLower(field.Name)]; ok {
+			keys = append(keys, strings.ToLower(field.Name))
+		}
+	}
+	return keys
+}
+
+// setStatus records a configuration version once it is parsed and valid,
+// a rejected one leaves the previous status in place and is processed again
+// on the next update
+func (s *Sync) setStatus(result syncResult, changed bool) {
+	if !changed {
+		return
+	}
+	s.latestVersion = result.Version
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.status = SyncStatus{
+		Source:          result.Source,
+		Version:         result.Version,
+		ResourceVersion: result.ResourceVersion,
+		Updated:         s.clock.Now(),
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestSyncStatus(t *testing.T) {
+	valid := syncResult{Version: "v1", ConfigData: []byte("numThreads: 2\n"), Source: "configmap", ResourceVersion: "10"}
+	invalid := syncResult{Version: "v2", ConfigData: []byte("numThreads: -1\nrateLimit: -2\nforwardZones:\n- name: example.com.\n"), Source: "configmap", ResourceVersion: "11"}
+	unparsable := syncResult{Version: "v3", ConfigData: []byte("numThreads: [\n"), Source: "configmap", ResourceVersion: "12"}
+	next := syncResult{Version: "v4", ConfigData: []byte("numThreads: 4\n"), Source: "configmap", ResourceVersion: "13"}
+	empty := syncResult{Source: "/etc/unbound/unbound.yaml"}
+
+	tests := []struct {
+		name        string
+		result      syncResult
+		wantErr     bool
+		wantChanged bool
+		wantStatus  SyncStatus
+	}{
+		{"valid", valid, false, true, SyncStatus{Source: "configmap", Version: "v1", ResourceVersion: "10"}},
+		{"unchanged", valid, false, false, SyncStatus{Source: "configmap", Version: "v1", ResourceVersion: "10"}},
+		{"invalid keeps the status", invalid, true, true, SyncStatus{Source: "configmap", Version: "v1", ResourceVersion: "10"}},
+		{"invalid is retried", invalid, true, true, SyncStatus{Source: "configmap", Version: "v1", ResourceVersion: "10"}},
+		{"unparsable keeps the status", unparsable, true, true, SyncStatus{Source: "configmap", Version: "v1", ResourceVersion: "10"}},
+		{"next valid", next, false, true, SyncStatus{Source: "configmap", Version: "v4", ResourceVersion: "13"}},
+		{"next unchanged", next, false, false, SyncStatus{Source: "configmap", Version: "v4", ResourceVersion: "13"}},
+		{"empty", empty, false, true, SyncStatus{Source: "/etc/unbound/unbound.yaml"}},
+	}
+
+	clock := testingclock.NewFakeClock(time.Date(2023, 10, 19, 0, 0, 0, 0, time.UTC))
+	s := &Sync{clock: clock}
+	for _, tt := range tests {
+		clock.Step(time.Minute)
+		updated := s.Current().Updated
+		_, changed, err := s.processUpdate(tt.result, false)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s: processUpdate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if changed != tt.wantChanged {
+			t.Errorf("%s: processUpdate() changed = %v, want %v", tt.name, changed, tt.wantChanged)
+		}
+		got := s.Current()
+		if got.Source != tt.wantStatus.Source || got.Version != tt.wantStatus.Version || got.ResourceVersion != tt.wantStatus.ResourceVersion {
+			t.Errorf("%s: Current() = %+v, want %+v", tt.name, got, tt.wantStatus)
+		}
+		if recorded := !tt.wantErr && tt.wantChanged; recorded != got.Updated.Equal(clock.Now()) || !recorded && !got.Updated.Equal(updated) {
+			t.Errorf("%s: Current().Updated = %v, now %v", tt.name, got.Updated, clock.Now())
+		}
+	}
+}
+
+func TestSyncOnceInvalid(t *testing.T) {
+	s := &Sync{
+		clock: testingclock.NewFakeClock(time.Now()),
+		loader: func() (syncResult, error) {
+			return syncResult{Version: "v1", ConfigData: []byte("numThreads: [\n"), Source: "test"}, nil
+		},
+	}
+	config, err := s.Once()
+	if err == nil {
+		t.Errorf("Once() accepted an unparsable configuration")
+	}
+	if config == nil {
+		t.Errorf("Once() returned a nil configuration")
+	}
+	if status := s.Current(); status.Version != "" {
+		t.Errorf("Current() = %+v after an unparsable configuration", status)
+	}
+}
+
+func TestRuntimeKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"none", "numThreads: 2\nverbosity: 1\n", nil},
+		{"runtime fields", "numThreads: 2\nport: 5353\nstatedir: /var/lib/unbound\npid: /run/unbound.pid\n", []string{"port", "pid", "statedir"}},
+		{"nested keys are not runtime fields", "logging:\n  port: 1\n", nil},
+		{"unparsable", "port: [\n", nil},
+	}
+	for _, tt := range tests {
+		if got := runtimeKeys([]byte(tt.data)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: runtimeKeys() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSyncRejectedEvents(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	events.SetRecorder(recorder, &v1.ObjectReference{Kind: "Pod", Namespace: "kube-system", Name: "node-cache-abcde"})
+	t.Cleanup(func() { events.SetRecorder(nil) })
+
+	tests := []struct {
+		name   string
+		result syncResult
+		want   string
+	}{
+		{
+			name:   "invalid",
+			result: syncResult{Version: "v1", ConfigData: []byte("forwardZones:\n- name: example.com.\n"), Source: "configmap"},
+			want:   "Warning ConfigRejected Configuration from configmap is rejected: ",
+		},
+		{
+			name:   "unparsable",
+			result: syncResult{Version: "v2", ConfigData: []byte("numThreads: [\n"), Source: "configmap"},
+			want:   "Warning ConfigRejected Unable to parse configuration from configmap: ",
+		},
+		{
+			name:   "valid",
+			result: syncResult{Version: "v3", ConfigData: []byte("numThreads: 2\n"), Source: "configmap"},
+		},
+	}
+	s := &Sync{clock: testingclock.NewFakeClock(time.Now())}
+	for _, tt := range tests {
+		s.processUpdate(tt.result, false)
+		select {
+		case event := <-recorder.Events:
+			if tt.want == "" || !strings.HasPrefix(event, tt.want) {
+				t.Errorf("%s: event %q, want %q", tt.name, event, tt.want)
+			}
+		default:
+			if tt.want != "" {
+				t.Errorf("%s: no event, want %q", tt.name, tt.want)
+			}
+		}
+	}
+}
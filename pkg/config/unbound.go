@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnboundBuild describes the unbound binary a configuration is rendered for,
+// as reported by "unbound -V". Only unbound-checkconf validates a configuration
+// for an unknown build, and the options gated on a version are not rendered.
+type UnboundBuild struct {
+	Version  string   `json:"version"`
+	Features []string `json:"features"`
+}
+
+// configureFeatures map configure options to the feature they compile in
+var configureFeatures = map[string]string{
+	"--enable-dnstap":                    "dnstap",
+	"--with-libnghttp2":                  "doh",
+	"--enable-cachedb":                   "cachedb",
+	"--with-libhiredis":                  "redis",
+	"--with-pythonmodule":                "python",
+	"--enable-subnet":                    "subnetcache",
+	"--enable-ipset":                     "ipset",
+	"--enable-ipsecmod":                  "ipsecmod",
+	"--with-libevent":                    "libevent",
+	"--enable-dnscrypt":                  "dnscrypt",
+	"--enable-systemd":                   "systemd",
+	"--with-dynlibmodule":                "dynlib",
+	"--enable-tfo-client":                "tcp-fastopen",
+	"--enable-tfo-server":                "tcp-fastopen",
+	"--with-libngtcp2":                   "doq",
+	"--enable-linux-ip-local-port-range": "ip-local-port-range",
+}
+
+// ParseUnboundBuild reads the output of "unbound -V": the version, the
+// linked modules and the features enabled on the configure line
+func ParseUnboundBuild(output string) UnboundBuild {
+	var build UnboundBuild
+	seen := make(map[string]bool)
+	add := func(feature string) {
+		if !seen[feature] {
+			seen[feature] = true
+			build.Features = append(build.Features, feature)
+		}
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Version "):
+			build.Version = strings.TrimSpace(strings.TrimPrefix(line, "Version "))
+		case strings.HasPrefix(line, "Configure line:"):
+			for _, option := range strings.Fields(strings.TrimPrefix(line, "Configure line:")) {
+				name, _, _ := strings.Cut(option, "=")
+				if feature, ok := configureFeatures[name]; ok {
+					add(feature)
+				}
+			}
+		case strings.HasPrefix(line, "Linked modules:"):
+			for _, module := range strings.Fields(strings.TrimPrefix(line, "Linked modules:")) {
+				add(module)
+			}
+		case strings.HasPrefix(line, "Linked libs:"):
+			if strings.Contains(line, "OpenSSL") || strings.Contains(line, "LibreSSL") {
+				add("tls")
+			}
+		case strings.HasPrefix(line, "TCP Fastopen feature available"):
+			add("tcp-fastopen")
+		}
+	}
+	return build
+}
+
+// HasFeature returns true if the module or feature is compiled in. An
+// unknown build is assumed capable, unbound-checkconf rejects what it is not.
+func (b UnboundBuild) HasFeature(name string) bool {
+	if b.Version == "" {
+		return true
+	}
+	for _, feature := range b.Features {
+		if feature == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AtLeast returns true if the version is the same as or newer than version.
+// Like HasFeature an unknown version is assumed to be.
+func (b UnboundBuild) AtLeast(version string) bool {
+	if b.Version == "" {
+		return true
+	}
+	have, want := versionParts(b.Version), versionParts(version)
+	for i := range want {
+		part := 0
+		if i < len(have) {
+			part = have[i]
+		}
+		if part != want[i] {
+			return part > want[i]
+		}
+	}
+	return true
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		// drop suffixes like 1.19.0rc1
+		digits := strings.TrimRightFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// ValidateFor refuses configuration sections the unbound build cannot support,
+// nothing is refused when the build is unknown and unbound-checkconf decides
+func (c *Config) ValidateFor(build UnboundBuild) error {
+	if build.Version == "" {
+		return nil
+	}
+	requirements := []struct {
+		used    bool
+		section string
+		feature string
+		version string
+	}{
+		{c.DNS64.Enabled, "dns64", "dns64", ""},
+		{c.DNSSEC.Enabled, "dnssec", "validator", ""},
+		{c.Cache.ServeExpiredClientTimeout > 0, "cache.serveExpiredClientTimeout", "", "1.11.0"},
+		{c.IPRateLimit > 0, "ipRateLimit", "", "1.7.0"},
+		{len(c.AuthZones) > 0, "authZones", "", "1.7.0"},
+		{len(c.Views) > 0, "views", "", "1.6.0"},
+		{c.Kubernetes.SearchPathOptimisation, "kubernetes.searchPathOptimisation", "", "1.8.0"},
+		{c.Kubernetes.SearchPathMaxLabels > 0, "kubernetes.searchPathMaxLabels", "python", ""},
+	}
+	for _, r := range requirements {
+		if !r.used {
+			continue
+		}
+		if r.feature != "" && !build.HasFeature(r.feature) {
+			return fmt.Errorf("%s needs unbound with the %s module, which unbound %s is built without", r.section, r.feature, build.Version)
+		}
+		if r.version != "" && !build.AtLeast(r.version) {
+			return fmt.Errorf("%s needs unbound %s or later, running %s", r.section, r.version, build.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnboundBuildAtLeast(t *testing.T) {
+	tests := []struct {
+		have string
+		want string
+		ok   bool
+	}{
+		{"", "1.99.0", true},
+		{"1.18.0", "1.18.0", true},
+		{"1.18.0", "1.11.0", true},
+		{"1.17.1", "1.18.0", false},
+		{"1.9.0", "1.11.0", false},
+		{"1.19.0rc1", "1.19.0", true},
+		{"1.19", "1.19.0", true},
+		{"1.19", "1.19.1", false},
+		{"2.0.0", "1.18.0", true},
+	}
+	for _, tt := range tests {
+		if got := (UnboundBuild{Version: tt.have}).AtLeast(tt.want); got != tt.ok {
+			t.Errorf("UnboundBuild{%q}.AtLeast(%q) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestParseUnboundBuild(t *testing.T) {
+	output := `Version 1.17.1
+
+Configure line: --prefix=/usr --with-libevent --with-pythonmodule --enable-tfo-client --enable-tfo-server
+Linked libs: libevent 2.1.12-stable (it uses epoll), OpenSSL 3.0.2 15 Mar 2022
+Linked modules: dns64 python respip validator iterator
+TCP Fastopen feature available
+`
+	want := UnboundBuild{
+		Version:  "1.17.1",
+		Features: []string{"libevent", "python", "tcp-fastopen", "tls", "dns64", "respip", "validator", "iterator"},
+	}
+	if got := ParseUnboundBuild(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseUnboundBuild() = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateFor(t *testing.T) {
+	build := UnboundBuild{Version: "1.10.0", Features: []string{"validator", "iterator"}}
+	tests := []struct {
+		name    string
+		build   UnboundBuild
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{"defaults", build, func(c *Config) {}, false},
+		{"dnssec", build, func(c *Config) { c.DNSSEC.Enabled = true }, false},
+		{"dns64 without the module", build, func(c *Config) { c.DNS64.Enabled = true }, true},
+		{"version too old", build, func(c *Config) { c.Cache.ServeExpiredClientTimeout = 1800 }, true},
+		{"python without the module", build, func(c *Config) {
+			c.Kubernetes.SearchPathOptimisation = true
+			c.Kubernetes.SearchPathMaxLabels = 4
+		}, true},
+		{"unknown build", UnboundBuild{}, func(c *Config) { c.DNS64.Enabled = true }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			tt.modify(c)
+			if err := c.ValidateFor(tt.build); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// protocolVersion prefixes every command, "UBCT1 status"
+	protocolVersion = "UBCT1 "
+	// DefaultTimeout bounds a command when neither the context nor the
+	// client set a deadline
+	DefaultTimeout = 10 * time.Second
+	// serverName is the name in the certificate made by unbound-control-setup
+	serverName = "unbound"
+)
+
+// Client speaks the unbound remote-control protocol over a unix socket or a
+// TLS connection, every command is sent on a new connection
+type Client struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	// Timeout bounds the commands sent without a context deadline,
+	// DefaultTimeout when 0
+	Timeout time.Duration
+}
+
+// NewUnixClient returns a client of a control-interface set to a socket path
+func NewUnixClient(path string) *Client {
+	return &Client{network: "unix", address: path}
+}
+
+// NewTLSClient returns a client of a control-interface listening on a TCP
+// address, which requires control-use-cert
+func NewTLSClient(address string, tlsConfig *tls.Config) *Client {
+	return &Client{network: "tcp", address: address, tlsConfig: tlsConfig}
+}
+
+// LoadTLSConfig reads the certificates made by unbound-control-setup: the
+// server certificate to trust, and the key and certificate of the client
+func LoadTLSConfig(serverCertFile, controlKeyFile, controlCertFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(controlCertFile, controlKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	serverCert, err := os.ReadFile(serverCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(serverCert) {
+		return nil, fmt.Errorf("no certificate found in %s", serverCertFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Command sends a command and returns its reply without the trailing newline
+func (c *Client) Command(ctx context.Context, command string) (string, error) {
+	var reply bytes.Buffer
+	if err := c.Stream(ctx, command, nil, &reply); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(reply.String(), "\n"), nil
+}
+
+// Stream sends a command followed by the data of in, terminated by the
+// end-of-file marker of unbound-control, and copies the reply to out
+func (c *Client) Stream(ctx context.Context, command string, in io.Reader, out io.Writer) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// closing the connection interrupts the reads and writes on cancellation
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	err = exchange(conn, command, in, out)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	// the connection deadline set from the context may expire first
+	if deadline, ok := ctx.Deadline(); ok && err != nil && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func exchange(conn net.Conn, command string, in io.Reader, out io.Writer) error {
+	if _, err := conn.Write([]byte(protocolVersion + command + "\n")); err != nil {
+		return err
+	}
+	if in != nil {
+		if _, err := io.Copy(conn, in); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte{0x04, '\n'}); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(out, conn)
+	return err
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = DefaultTimeout
+		}
+		deadline = time.Now().Add(timeout)
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+
+	if c.tlsConfig == nil {
+		return conn, nil
+	}
+	tlsConn := tls.Client(conn, c.tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// IsDialError returns true when the control-interface could not be reached
+func IsDialError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
@@ -0,0 +1,369 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is a unix socket control-interface answering with reply,
+// the commands and the streamed data it received are sent to requests
+type fakeServer struct {
+	path     string
+	listener net.Listener
+	requests chan request
+}
+
+type request struct {
+	command string
+	data    string
+}
+
+// reply returns the reply to a command, hang keeps the connection open
+type replyFunc func(command string) (reply string, hang bool)
+
+func newFakeServer(t *testing.T, reply replyFunc) *fakeServer {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{path: path, listener: listener, requests: make(chan request, 16)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve(reply)
+	return s
+}
+
+func (s *fakeServer) serve(reply replyFunc) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn, reply)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn, reply replyFunc) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, protocolVersion) {
+		return
+	}
+	req := request{command: strings.TrimSuffix(strings.TrimPrefix(line, protocolVersion), "\n")}
+	if req.command == "load_cache" {
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\x04\n" {
+				break
+			}
+			data.WriteString(line)
+		}
+		req.data = data.String()
+	}
+	s.requests <- req
+
+	answer, hang := reply(req.command)
+	if hang {
+		// wait for the client to give up
+		io.Copy(io.Discard, r)
+		return
+	}
+	conn.Write([]byte(answer))
+}
+
+func replies(answers map[string]string) replyFunc {
+	return func(command string) (string, bool) {
+		answer, ok := answers[command]
+		if !ok {
+			return "error unknown command\n", false
+		}
+		return answer, false
+	}
+}
+
+const statusReply = `version: 1.19.1
+verbosity: 1
+threads: 2
+modules: 2 [ validator iterator ]
+uptime: 42 seconds
+options: reuseport control(namedpipe)
+unbound (pid 1234) is running...
+`
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    *Status
+		wantErr bool
+	}{
+		{
+			name:  "running",
+			reply: statusReply,
+			want: &Status{
+				Version:   "1.19.1",
+				Verbosity: 1,
+				Threads:   2,
+				Modules:   []string{"validator", "iterator"},
+				Uptime:    42 * time.Second,
+				Options:   []string{"reuseport", "control(namedpipe)"},
+				PID:       1234,
+			},
+		},
+		{
+			name:  "pid only",
+			reply: "unbound (pid 99) is running...\n",
+			want:  &Status{PID: 99},
+		},
+		{
+			name:    "no pid",
+			reply:   "version: 1.19.1\n",
+			wantErr: true,
+		},
+		{
+			name:    "error",
+			reply:   "error: not running\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, replies(map[string]string{"status": tt.reply}))
+			got, err := NewUnixClient(s.path).Status(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Status() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.PID != tt.want.PID || got.Version != tt.want.Version || got.Threads != tt.want.Threads ||
+				got.Verbosity != tt.want.Verbosity || got.Uptime != tt.want.Uptime ||
+				strings.Join(got.Modules, " ") != strings.Join(tt.want.Modules, " ") ||
+				strings.Join(got.Options, " ") != strings.Join(tt.want.Options, " ") {
+				t.Errorf("Status() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOKReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		call    func(*Client) error
+		command string
+		wantErr bool
+	}{
+		{
+			name:    "reload",
+			reply:   "ok\n",
+			call:    func(c *Client) error { return c.Reload(context.Background(), false) },
+			command: "reload",
+		},
+		{
+			name:    "reload keeping the cache",
+			reply:   "ok\n",
+			call:    func(c *Client) error { return c.Reload(context.Background(), true) },
+			command: "reload_keep_cache",
+		},
+		{
+			name:    "reload refused",
+			reply:   "error unknown command 'reload_keep_cache'\n",
+			call:    func(c *Client) error { return c.Reload(context.Background(), true) },
+			command: "reload_keep_cache",
+			wantErr: true,
+		},
+		{
+			name:    "flush zone counters",
+			reply:   "ok removed 3 rrsets, 2 messages and 0 key entries\n",
+			call:    func(c *Client) error { return c.FlushZone(context.Background(), "example.com.") },
+			command: "flush_zone example.com.",
+		},
+		{
+			name:    "flush zone error",
+			reply:   "error parsing name\n",
+			call:    func(c *Client) error { return c.FlushZone(context.Background(), "..") },
+			command: "flush_zone ..",
+			wantErr: true,
+		},
+		{
+			name:  "insecure forward",
+			reply: "ok\n",
+			call: func(c *Client) error {
+				return c.ForwardAdd(context.Background(), "example.com.", true, "192.0.2.1", "192.0.2.2")
+			},
+			command: "forward_add +i example.com. 192.0.2.1 192.0.2.2",
+		},
+		{
+			name:    "unexpected reply",
+			reply:   "done\n",
+			call:    func(c *Client) error { return c.Verbosity(context.Background(), 2) },
+			command: "verbosity 2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, func(string) (string, bool) { return tt.reply, false })
+			err := tt.call(NewUnixClient(s.path))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !IsReplyError(err) {
+				t.Errorf("IsReplyError(%v) = false", err)
+			}
+			if req := <-s.requests; req.command != tt.command {
+				t.Errorf("command = %q, want %q", req.command, tt.command)
+			}
+		})
+	}
+}
+
+func TestReplyErrorMessage(t *testing.T) {
+	tests := []struct {
+		reply string
+		want  string
+	}{
+		{"error unknown command 'reload_keep_cache'", "unknown command 'reload_keep_cache'"},
+		{"error: not a zone", "not a zone"},
+		{"done", "unexpected reply: done"},
+	}
+	for _, tt := range tests {
+		if got := (&ReplyError{Reply: tt.reply}).Error(); got != tt.want {
+			t.Errorf("ReplyError{%q}.Error() = %q, want %q", tt.reply, got, tt.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := newFakeServer(t, replies(map[string]string{
+		"stats_noreset": "total.num.queries=12\ntotal.recursion.time.avg=0.012500\n",
+		"stats":         "total.num.queries=oops\n",
+	}))
+	c := NewUnixClient(s.path)
+
+	stats, err := c.Stats(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["total.num.queries"] != 12 || stats["total.recursion.time.avg"] != 0.0125 {
+		t.Errorf("Stats() = %v", stats)
+	}
+	if _, err := c.Stats(context.Background(), true); err == nil {
+		t.Errorf("Stats() accepted an invalid value")
+	}
+}
+
+func TestListForwards(t *testing.T) {
+	s := newFakeServer(t, replies(map[string]string{
+		"list_forwards": "example.com. IN forward +i 192.0.2.1 192.0.2.2\n. IN forward 8.8.8.8\n",
+	}))
+	forwards, err := NewUnixClient(s.path).ListForwards(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards) != 2 {
+		t.Fatalf("ListForwards() = %+v", forwards)
+	}
+	if f := forwards[0]; f.Zone != "example.com." || strings.Join(f.Flags, " ") != "+i" || strings.Join(f.Servers, " ") != "192.0.2.1 192.0.2.2" {
+		t.Errorf("ListForwards()[0] = %+v", f)
+	}
+	if f := forwards[1]; f.Zone != "." || len(f.Flags) != 0 || strings.Join(f.Servers, " ") != "8.8.8.8" {
+		t.Errorf("ListForwards()[1] = %+v", f)
+	}
+}
+
+func TestCacheStreaming(t *testing.T) {
+	dump := "START_RRSET_CACHE\n;rrset 3600 1 0 2 0\nexample.com.\t3600\tIN\tA\t192.0.2.1\nEND_RRSET_CACHE\nEOF\n"
+	s := newFakeServer(t, replies(map[string]string{"dump_cache": dump, "load_cache": "ok\n"}))
+	c := NewUnixClient(s.path)
+
+	var out bytes.Buffer
+	if err := c.DumpCache(context.Background(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != dump {
+		t.Errorf("DumpCache() = %q, want %q", out.String(), dump)
+	}
+	<-s.requests
+
+	if err := c.LoadCache(context.Background(), strings.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if req := <-s.requests; req.data != dump {
+		t.Errorf("load_cache data = %q, want %q", req.data, dump)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s := newFakeServer(t, func(string) (string, bool) { return "", true })
+
+	c := NewUnixClient(s.path)
+	c.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	_, err := c.Command(context.Background(), "status")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("Command() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Command() returned after %v", elapsed)
+	}
+
+	// the context deadline replaces the timeout of the client
+	c.Timeout = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.Command(ctx, "status"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Command() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s := newFakeServer(t, func(string) (string, bool) { return "", true })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.requests
+		cancel()
+	}()
+	c := NewUnixClient(s.path)
+	c.Timeout = time.Hour
+	if err := c.DumpCache(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("DumpCache() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestIsDialError(t *testing.T) {
+	c := NewUnixClient(filepath.Join(t.TempDir(), "missing.sock"))
+	_, err := c.Command(context.Background(), "status")
+	if !IsDialError(err) {
+		t.Errorf("IsDialError(%v) = false", err)
+	}
+	if IsReplyError(err) {
+		t.Errorf("IsReplyError(%v) = true", err)
+	}
+
+	s := newFakeServer(t, replies(nil))
+	err = NewUnixClient(s.path).Flush(context.Background(), "example.com.")
+	if IsDialError(err) || !IsReplyError(err) {
+		t.Errorf("error reply %v is taken for a dial error", err)
+	}
+	if IsDialError(nil) || IsDialError(context.Canceled) {
+		t.Errorf("IsDialError() is true for an error which is not a dial error")
+	}
+}
@@ -0,0 +1,302 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusPidPattern matches the last line of the status reply, "unbound (pid 1234) is running..."
+var statusPidPattern = regexp.MustCompile(`^unbound \(pid ([0-9]+)\) is running`)
+
+// Status is the reply of the status command
+type Status struct {
+	Version   string
+	Verbosity int
+	Threads   int
+	Modules   []string
+	Uptime    time.Duration
+	Options   []string
+	PID       int
+}
+
+// Forward is a forward or stub zone of the list_forwards and list_stubs replies
+type Forward struct {
+	Zone  string
+	Class string
+	Type  string
+	// Flags are the options of the zone, like +i for an insecure zone
+	Flags   []string
+	Servers []string
+}
+
+// Stats are the counters of the stats reply, like total.num.queries
+type Stats map[string]float64
+
+// Status returns the version, the uptime and the pid of unbound
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	reply, err := c.Command(ctx, "status")
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	for _, line := range strings.Split(reply, "\n") {
+		if m := statusPidPattern.FindStringSubmatch(line); m != nil {
+			status.PID, _ = strconv.Atoi(m[1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "version":
+			status.Version = value
+		case "verbosity":
+			status.Verbosity, _ = strconv.Atoi(value)
+		case "threads":
+			status.Threads, _ = strconv.Atoi(value)
+		case "modules":
+			// "2 [ validator iterator ]"
+			if _, modules, ok := strings.Cut(value, "["); ok {
+				status.Modules = strings.Fields(strings.TrimSuffix(strings.TrimSpace(modules), "]"))
+			}
+		case "uptime":
+			if seconds, err := strconv.Atoi(strings.TrimSuffix(value, " seconds")); err == nil {
+				status.Uptime = time.Duration(seconds) * time.Second
+			}
+		case "options":
+			status.Options = strings.Fields(value)
+		}
+	}
+	if status.PID == 0 {
+		return nil, fmt.Errorf("unexpected status reply: %s", reply)
+	}
+	return status, nil
+}
+
+// Stats returns the statistics, resetting the counters when reset is set
+func (c *Client) Stats(ctx context.Context, reset bool) (Stats, error) {
+	var reply bytes.Buffer
+	if err := c.StatsTo(ctx, reset, &reply); err != nil {
+		return nil, err
+	}
+	stats := make(Stats)
+	scanner := bufio.NewScanner(&reply)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a valid key-value pair", scanner.Text())
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid key-value pair", scanner.Text())
+		}
+		stats[key] = number
+	}
+	return stats, scanner.Err()
+}
+
+// StatsTo copies the raw statistics to out, one key=value per line
+func (c *Client) StatsTo(ctx context.Context, reset bool, out io.Writer) error {
+	command := "stats_noreset"
+	if reset {
+		command = "stats"
+	}
+	return c.Stream(ctx, command, nil, out)
+}
+
+// ListAuthZonesTo copies the list_auth_zones reply to out, one zone per line
+func (c *Client) ListAuthZonesTo(ctx context.Context, out io.Writer) error {
+	return c.Stream(ctx, "list_auth_zones", nil, out)
+}
+
+// Reload rereads the configuration, keeping the cache when keepCache is set,
+// which needs unbound 1.18 or later
+func (c *Client) Reload(ctx context.Context, keepCache bool) error {
+	if keepCache {
+		return c.ok(ctx, "reload_keep_cache")
+	}
+	return c.ok(ctx, "reload")
+}
+
+// Flush removes a name from the cache
+func (c *Client) Flush(ctx context.Context, name string) error {
+	return c.ok(ctx, "flush "+name)
+}
+
+// FlushZone removes a name and everything below it from the cache
+func (c *Client) FlushZone(ctx context.Context, name string) error {
+	return c.okPrefix(ctx, "flush_zone "+name)
+}
+
+// FlushInfra removes an upstream address from the infrastructure cache,
+// "all" removes every one
+func (c *Client) FlushInfra(ctx context.Context, address string) error {
+	return c.ok(ctx, "flush_infra "+address)
+}
+
+// FlushRequestList drops the queries being resolved
+func (c *Client) FlushRequestList(ctx context.Context) error {
+	return c.ok(ctx, "flush_requestlist")
+}
+
+// Lookup returns the lines describing the delegation and the upstreams used
+// for a name
+func (c *Client) Lookup(ctx context.Context, name string) ([]string, error) {
+	reply, err := c.Command(ctx, "lookup "+name)
+	if err != nil {
+		return nil, err
+	}
+	if err := replyError(reply); err != nil {
+		return nil, err
+	}
+	return strings.Split(reply, "\n"), nil
+}
+
+// ListForwards returns the forward zones
+func (c *Client) ListForwards(ctx context.Context) ([]Forward, error) {
+	reply, err := c.Command(ctx, "list_forwards")
+	if err != nil {
+		return nil, err
+	}
+	if err := replyError(reply); err != nil {
+		return nil, err
+	}
+	var forwards []Forward
+	for _, line := range strings.Split(reply, "\n") {
+		// "example.com. IN forward +i 192.0.2.1 192.0.2.2"
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		forward := Forward{Zone: fields[0], Class: fields[1], Type: fields[2]}
+		for _, field := range fields[3:] {
+			if strings.HasPrefix(field, "+") {
+				forward.Flags = append(forward.Flags, field)
+			} else {
+				forward.Servers = append(forward.Servers, field)
+			}
+		}
+		forwards = append(forwards, forward)
+	}
+	return forwards, nil
+}
+
+// ForwardAdd adds a forward zone, without DNSSEC validation when insecure is set
+func (c *Client) ForwardAdd(ctx context.Context, zone string, insecure bool, servers ...string) error {
+	command := "forward_add "
+	if insecure {
+		command += "+i "
+	}
+	return c.ok(ctx, command+zone+" "+strings.Join(servers, " "))
+}
+
+// ForwardRemove removes a forward zone, insecure removes its domain-insecure too
+func (c *Client) ForwardRemove(ctx context.Context, zone string, insecure bool) error {
+	command := "forward_remove "
+	if insecure {
+		command += "+i "
+	}
+	return c.ok(ctx, command+zone)
+}
+
+// LocalData adds a local resource record, like "example.com. 3600 IN A 192.0.2.1"
+func (c *Client) LocalData(ctx context.Context, rr string) error {
+	return c.ok(ctx, "local_data "+rr)
+}
+
+// LocalDataRemove removes the local data of a name
+func (c *Client) LocalDataRemove(ctx context.Context, name string) error {
+	return c.ok(ctx, "local_data_remove "+name)
+}
+
+// LocalZone adds a local zone of a type like static or always_nxdomain
+func (c *Client) LocalZone(ctx context.Context, name, zoneType string) error {
+	return c.ok(ctx, "local_zone "+name+" "+zoneType)
+}
+
+// LocalZoneRemove removes a local zone and its data
+func (c *Client) LocalZoneRemove(ctx context.Context, name string) error {
+	return c.ok(ctx, "local_zone_remove "+name)
+}
+
+// DumpCache writes the cache to out, the dump ends with an EOF line
+func (c *Client) DumpCache(ctx context.Context, out io.Writer) error {
+	return c.Stream(ctx, "dump_cache", nil, out)
+}
+
+// LoadCache loads a cache written by DumpCache
+func (c *Client) LoadCache(ctx context.Context, in io.Reader) error {
+	var reply bytes.Buffer
+	if err := c.Stream(ctx, "load_cache", in, &reply); err != nil {
+		return err
+	}
+	return expectOK(strings.TrimSpace(reply.String()))
+}
+
+// Verbosity sets the log verbosity of unbound
+func (c *Client) Verbosity(ctx context.Context, level int) error {
+	return c.ok(ctx, "verbosity "+strconv.Itoa(level))
+}
+
+// ok sends a command replied to with "ok"
+func (c *Client) ok(ctx context.Context, command string) error {
+	reply, err := c.Command(ctx, command)
+	if err != nil {
+		return err
+	}
+	return expectOK(strings.TrimSpace(reply))
+}
+
+// okPrefix sends a command replied to with "ok" followed by counters, like
+// "ok removed 3 rrsets, 2 messages and 0 key entries"
+func (c *Client) okPrefix(ctx context.Context, command string) error {
+	reply, err := c.Command(ctx, command)
+	if err != nil {
+		return err
+	}
+	if reply = strings.TrimSpace(reply); !strings.HasPrefix(reply, "ok") {
+		return &ReplyError{Reply: reply}
+	}
+	return nil
+}
+
+// ReplyError is the reply of a command unbound failed or did not understand
+type ReplyError struct {
+	Reply string
+}
+
+func (e *ReplyError) Error() string {
+	if message, ok := strings.CutPrefix(e.Reply, "error"); ok {
+		return strings.TrimSpace(strings.TrimPrefix(message, ":"))
+	}
+	return "unexpected reply: " + e.Reply
+}
+
+// IsReplyError returns true when unbound answered a command with an error
+func IsReplyError(err error) bool {
+	_, ok := err.(*ReplyError)
+	return ok
+}
+
+func expectOK(reply string) error {
+	if reply != "ok" {
+		return &ReplyError{Reply: reply}
+	}
+	return nil
+}
+
+// replyError returns the error of a reply starting with "error"
+func replyError(reply string) error {
+	if strings.HasPrefix(reply, "error") {
+		return &ReplyError{Reply: reply}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+// Reasons of the recorded Events
+const (
+	ReasonConfigRejected      = "ConfigRejected"
+	ReasonCheckconfFailed     = "CheckconfFailed"
+	ReasonReloadFailed        = "ReloadFailed"
+	ReasonUnboundExited       = "UnboundExited"
+	ReasonUnboundRestarted    = "UnboundRestarted"
+	ReasonUnboundDegraded     = "UnboundDegraded"
+	ReasonResourcesNotApplied = "ResourcesNotApplied"
+	ReasonIptablesRepaired    = "IptablesRepaired"
+	ReasonInterfaceRepaired   = "InterfaceRepaired"
+)
+
+var (
+	recorder record.EventRecorder
+	refs     []*v1.ObjectReference
+)
+
+// InitEvents starts recording Events against the Pod and the Node node-cache
+// runs on. Either of them is skipped when its name is empty.
+// Similar Events are aggregated and rate limited by the broadcaster.
+func InitEvents(client kubernetes.Interface, podNamespace, podName, nodeName string) {
+	var objects []*v1.ObjectReference
+	if podName != "" {
+		ref := &v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: podNamespace, Name: podName}
+		// the UID is needed for the Events to be listed with the Pod
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if pod, err := client.CoreV1().Pods(podNamespace).Get(ctx, podName, metav1.GetOptions{}); err == nil {
+			ref.UID = pod.UID
+		} else {
+			klog.Warningf("Unable to get Pod %s/%s for events: %v", podNamespace, podName, err)
+		}
+		objects = append(objects, ref)
+	}
+	if nodeName != "" {
+		// same as the kubelet, the UID of a Node reference is its name
+		objects = append(objects, &v1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: nodeName, UID: types.UID(nodeName)})
+	}
+
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		// one event per 5 minutes per object and reason after the burst
+		QPS:       1. / 300.,
+		BurstSize: 5,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	SetRecorder(broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "nodelocaldns", Host: nodeName}), objects...)
+	klog.V(1).Infof("Recording events for %d objects", len(objects))
+}
+
+// SetRecorder records the Events with r against every object, a nil r stops
+// recording. InitEvents sets a recorder sending them to the API server.
+func SetRecorder(r record.EventRecorder, objects ...*v1.ObjectReference) {
+	recorder, refs = r, objects
+}
+
+// Warning records a warning Event, it is a no-op until InitEvents is called
+func Warning(reason, messageFmt string, args ...interface{}) {
+	publish(v1.EventTypeWarning, reason, messageFmt, args...)
+}
+
+// Normal records a normal Event, it is a no-op until InitEvents is called
+func Normal(reason, messageFmt string, args ...interface{}) {
+	publish(v1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+func publish(eventType, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	for _, ref := range refs {
+		recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+	}
+}
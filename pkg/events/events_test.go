@@ -0,0 +1,96 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// receive returns the Events recorded by r, waiting for want of them
+func receive(t *testing.T, r *record.FakeRecorder, want int) []string {
+	t.Helper()
+	var events []string
+	for len(events) < want {
+		select {
+		case event := <-r.Events:
+			events = append(events, event)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d events %q, want %d", len(events), events, want)
+		}
+	}
+	select {
+	case event := <-r.Events:
+		t.Errorf("unexpected event %q", event)
+	default:
+	}
+	return events
+}
+
+func TestPublish(t *testing.T) {
+	t.Cleanup(func() { SetRecorder(nil) })
+
+	// no-op without a recorder
+	Warning(ReasonConfigRejected, "rejected")
+
+	r := record.NewFakeRecorder(10)
+	r.IncludeObject = true
+	SetRecorder(r,
+		&v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: "kube-system", Name: "node-cache-abcde"},
+		&v1.ObjectReference{Kind: "Node", APIVersion: "v1", Name: "node-1"})
+
+	Warning(ReasonConfigRejected, "Configuration from %s is rejected: %v", "configmap", "numThreads is negative")
+	Normal(ReasonUnboundRestarted, "unbound restarted")
+
+	want := []string{
+		"Warning ConfigRejected Configuration from configmap is rejected: numThreads is negative involvedObject{kind=Pod,apiVersion=v1}",
+		"Warning ConfigRejected Configuration from configmap is rejected: numThreads is negative involvedObject{kind=Node,apiVersion=v1}",
+		"Normal UnboundRestarted unbound restarted involvedObject{kind=Pod,apiVersion=v1}",
+		"Normal UnboundRestarted unbound restarted involvedObject{kind=Node,apiVersion=v1}",
+	}
+	got := receive(t, r, len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestInitEvents(t *testing.T) {
+	t.Cleanup(func() { SetRecorder(nil) })
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "node-cache-abcde", UID: "1234"}}
+	client := fake.NewSimpleClientset(pod)
+
+	InitEvents(client, "kube-system", "node-cache-abcde", "node-1")
+	Warning(ReasonUnboundExited, "unbound exited")
+
+	// the broadcaster writes the Events in the background
+	deadline := time.Now().Add(5 * time.Second)
+	var events *v1.EventList
+	for {
+		var err error
+		events, err = client.CoreV1().Events("").List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events.Items) >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	uids := make(map[string]string)
+	for _, event := range events.Items {
+		if event.Reason != ReasonUnboundExited || event.Type != v1.EventTypeWarning || event.Source.Host != "node-1" {
+			t.Errorf("unexpected event %+v", event)
+		}
+		uids[event.InvolvedObject.Kind] = string(event.InvolvedObject.UID)
+	}
+	if uids["Pod"] != "1234" || uids["Node"] != "node-1" {
+		t.Errorf("events are recorded for %v, want the Pod UID 1234 and the Node node-1", uids)
+	}
+}
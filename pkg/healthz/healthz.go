@@ -18,11 +18,34 @@ type Provider struct {
 	Name   string
 }
 
+// CheckFunc adapts a function to Checkable
+type CheckFunc func() error
+
+// Healthz calls the function
+func (f CheckFunc) Healthz() error {
+	return f()
+}
+
+// Reporter Makes sure the object has the Status() function
+type Reporter interface {
+	Status() interface{}
+}
+
+// StatusProvider is a provider which reports its runtime state on the status endpoint
+type StatusProvider struct {
+	Handle Reporter
+	Name   string
+}
+
 // Instance contains the healthz instance
 type Instance struct {
 	Providers []Provider
-	Detailed  bool
-	FailCode  int
+	// ReadinessProviders are checked with the Providers on the readiness
+	// endpoint only, they fail while the node-cache cannot serve yet
+	ReadinessProviders []Provider
+	StatusProviders    []StatusProvider
+	Detailed           bool
+	FailCode           int
 }
 
 // Error the structure of the Error object
@@ -46,8 +69,22 @@ type Response struct {
 
 // Healthz returns a http.HandlerFunc for the healthz service
 func (h *Instance) Healthz() http.HandlerFunc {
-
 	klog.V(1).Info("[Healthz] health service started")
+	return h.check(func() []Provider { return h.Providers })
+}
+
+// Readyz returns a http.HandlerFunc for the readiness probe, which checks the
+// readiness providers on top of the healthz ones
+func (h *Instance) Readyz() http.HandlerFunc {
+	klog.V(1).Info("[Healthz] readiness service started")
+	return h.check(func() []Provider {
+		return append(append([]Provider{}, h.Providers...), h.ReadinessProviders...)
+	})
+}
+
+// check returns a http.HandlerFunc checking the providers, which are
+// listed on every request as they are added after the server is started
+func (h *Instance) check(providers func() []Provider) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
@@ -57,8 +94,8 @@ func (h *Instance) Healthz() http.HandlerFunc {
 		// Let's check if we have any providers
 		// If we don't we should just return 200 OK
 		// As long as the web server is running we will assume it's all good
-		if h.Providers != nil {
-			for _, provider := range h.Providers {
+		if providers := providers(); providers != nil {
+			for _, provider := range providers {
 				comp := Component{
 					Name:    provider.Name,
 					Healthy: true,
@@ -104,6 +141,28 @@ func (h *Instance) Healthz() http.HandlerFunc {
 	})
 }
 
+// Status returns a http.HandlerFunc with the state reported by the status providers
+func (h *Instance) Status() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		status := make(map[string]interface{})
+		for _, provider := range h.StatusProviders {
+			status[provider.Name] = provider.Handle.Status()
+		}
+
+		json, err := json.Marshal(status)
+		if err != nil {
+			klog.Errorf("Unable to marshal status: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(json)
+	})
+}
+
 // Liveness returns a http.HandlerFunc for the liveness probe
 func (h *Instance) Liveness() http.HandlerFunc {
 	klog.V(1).Info("[Healthz] Liveness service started")
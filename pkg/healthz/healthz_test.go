@@ -0,0 +1,42 @@
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	healthy := CheckFunc(func() error { return nil })
+	failing := CheckFunc(func() error { return errors.New("failing") })
+
+	tests := []struct {
+		name        string
+		providers   []Provider
+		readiness   []Provider
+		wantHealthz int
+		wantReadyz  int
+	}{
+		{"no providers", nil, nil, http.StatusOK, http.StatusOK},
+		{"healthy", []Provider{{healthy, "a"}}, []Provider{{healthy, "b"}}, http.StatusOK, http.StatusOK},
+		{"not ready", []Provider{{healthy, "a"}}, []Provider{{failing, "b"}}, http.StatusOK, http.StatusServiceUnavailable},
+		{"unhealthy", []Provider{{failing, "a"}}, []Provider{{healthy, "b"}}, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Instance{Providers: tt.providers, ReadinessProviders: tt.readiness}
+			for path, handler := range map[string]http.HandlerFunc{"/healthz": h.Healthz(), "/readyz": h.Readyz()} {
+				want := tt.wantHealthz
+				if path == "/readyz" {
+					want = tt.wantReadyz
+				}
+				rec := httptest.NewRecorder()
+				handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != want {
+					t.Errorf("%s returned %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
@@ -34,7 +34,11 @@ func (h *HealthServer) Handle() (*http.Server, error) {
 
 	// Add the webserver to the list of healthz providers?
 	mux.Handle("/healthz", h.Instance.Healthz())
+	mux.Handle("/readyz", h.Instance.Readyz())
 	mux.Handle("/liveness", h.Instance.Liveness())
+	// the liveness path of the CoreDNS health plugin used by node-local-dns manifests
+	mux.Handle("/health", h.Instance.Liveness())
+	mux.Handle("/status", h.Instance.Status())
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%s", h.ListenPort),
@@ -0,0 +1,38 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewClient returns a kubernetes client using the in-cluster credentials,
+// or the given kubeconfig when running outside of the cluster
+func NewClient(kubeconfig string) (kubernetes.Interface, error) {
+	var (
+		cfg *rest.Config
+		err error
+	)
+	if kubeconfig != "" {
+		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		cfg, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubernetes client configuration: %v", err)
+	}
+	cfg.UserAgent = "nodelocaldns"
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+// SplitName splits a "namespace/name" reference, the namespace defaults to kube-system
+func SplitName(ref string) (string, string) {
+	if namespace, name, found := strings.Cut(ref, "/"); found {
+		return namespace, name
+	}
+	return "kube-system", ref
+}
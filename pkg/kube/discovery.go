@@ -0,0 +1,203 @@
+package kube
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// DiscoveredService is the state of the watched cluster DNS Service
+type DiscoveredService struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	ClusterIPs []string  `json:"clusterIPs,omitempty"`
+	Endpoints  []string  `json:"endpoints,omitempty"`
+	Updated    time.Time `json:"updated,omitempty"`
+}
+
+// ServiceDiscovery watches a Service and its Endpoints, usually kube-dns,
+// and reports the addresses to forward the cluster domain to
+type ServiceDiscovery struct {
+	client       kubernetes.Interface
+	namespace    string
+	name         string
+	useEndpoints bool
+
+	lock    sync.RWMutex
+	current DiscoveredService
+	changes chan struct{}
+	synced  []cache.InformerSynced
+}
+
+// NewServiceDiscovery returns a discovery for the namespace/name Service.
+// With useEndpoints the endpoint addresses are used instead of the ClusterIP.
+func NewServiceDiscovery(client kubernetes.Interface, namespace, name string, useEndpoints bool) *ServiceDiscovery {
+	return &ServiceDiscovery{
+		client:       client,
+		namespace:    namespace,
+		name:         name,
+		useEndpoints: useEndpoints,
+		current:      DiscoveredService{Namespace: namespace, Name: name},
+		changes:      make(chan struct{}, 1),
+	}
+}
+
+// Start watches the Service and Endpoints until stopCh is closed
+func (d *ServiceDiscovery) Start(stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(d.client, 0,
+		informers.WithNamespace(d.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", d.name).String()
+		}))
+
+	services := factory.Core().V1().Services().Informer()
+	services.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.onService(obj) },
+		UpdateFunc: func(_, obj interface{}) { d.onService(obj) },
+		DeleteFunc: func(obj interface{}) { d.onServiceDeleted() },
+	})
+
+	endpoints := factory.Core().V1().Endpoints().Informer()
+	endpoints.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { d.onEndpoints(obj) },
+		UpdateFunc: func(_, obj interface{}) { d.onEndpoints(obj) },
+		DeleteFunc: func(obj interface{}) { d.onEndpointsDeleted() },
+	})
+
+	d.synced = []cache.InformerSynced{services.HasSynced, endpoints.HasSynced}
+	factory.Start(stopCh)
+}
+
+// WaitForSync blocks until the initial state is known or the timeout expires
+func (d *ServiceDiscovery) WaitForSync(timeout time.Duration) bool {
+	stopCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stopCh) })
+	defer timer.Stop()
+	return cache.WaitForCacheSync(stopCh, d.synced...)
+}
+
+// Changes is notified every time the discovered servers change
+func (d *ServiceDiscovery) Changes() <-chan struct{} {
+	return d.changes
+}
+
+// Servers returns the addresses the cluster domain should be forwarded to
+func (d *ServiceDiscovery) Servers() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.servers()
+}
+
+func (d *ServiceDiscovery) servers() []string {
+	if d.useEndpoints || len(d.current.ClusterIPs) == 0 {
+		return append([]string{}, d.current.Endpoints...)
+	}
+	return append([]string{}, d.current.ClusterIPs...)
+}
+
+// Status reports the discovered Service
+func (d *ServiceDiscovery) Status() interface{} {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.current
+}
+
+func (d *ServiceDiscovery) onService(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok || svc.Name != d.name {
+		return
+	}
+	ips := make([]string, 0, len(svc.Spec.ClusterIPs))
+	for _, ip := range svc.Spec.ClusterIPs {
+		if net.ParseIP(ip) != nil {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 && net.ParseIP(svc.Spec.ClusterIP) != nil {
+		ips = append(ips, svc.Spec.ClusterIP)
+	}
+	d.update(func(s *DiscoveredService) { s.ClusterIPs = ips })
+}
+
+func (d *ServiceDiscovery) onServiceDeleted() {
+	d.update(func(s *DiscoveredService) { s.ClusterIPs = nil })
+}
+
+func (d *ServiceDiscovery) onEndpoints(obj interface{}) {
+	ep, ok := obj.(*v1.Endpoints)
+	if !ok || ep.Name != d.name {
+		return
+	}
+	addrs := make([]string, 0)
+	for _, subset := range ep.Subsets {
+		port := dnsPort(subset.Ports)
+		for _, addr := range subset.Addresses {
+			if port == 53 {
+				addrs = append(addrs, addr.IP)
+			} else {
+				addrs = append(addrs, addr.IP+"@"+strconv.Itoa(port))
+			}
+		}
+	}
+	sort.Strings(addrs)
+	d.update(func(s *DiscoveredService) { s.Endpoints = addrs })
+}
+
+func (d *ServiceDiscovery) onEndpointsDeleted() {
+	d.update(func(s *DiscoveredService) { s.Endpoints = nil })
+}
+
+func (d *ServiceDiscovery) update(change func(s *DiscoveredService)) {
+	d.lock.Lock()
+	before := fmt.Sprint(d.current.ClusterIPs, d.current.Endpoints)
+	servers := fmt.Sprint(d.servers())
+	change(&d.current)
+	if before != fmt.Sprint(d.current.ClusterIPs, d.current.Endpoints) {
+		d.current.Updated = time.Now()
+		klog.V(2).Infof("Service %s/%s changed: clusterIPs %v, endpoints %v",
+			d.namespace, d.name, d.current.ClusterIPs, d.current.Endpoints)
+	}
+	// only the servers in use are reconfigured, the endpoints change
+	// without them when the ClusterIP is forwarded to
+	changed := servers != fmt.Sprint(d.servers())
+	d.lock.Unlock()
+
+	if changed {
+		// don't block, a pending notification covers this change as well
+		select {
+		case d.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dnsPort returns the UDP port named "dns", or the first UDP port of the endpoints
+func dnsPort(ports []v1.EndpointPort) int {
+	port := 0
+	for _, p := range ports {
+		if p.Protocol != v1.ProtocolUDP {
+			continue
+		}
+		if p.Name == "dns" {
+			return int(p.Port)
+		}
+		if port == 0 {
+			port = int(p.Port)
+		}
+	}
+	if port == 0 {
+		return 53
+	}
+	return port
+}
@@ -0,0 +1,143 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func dnsService(clusterIPs ...string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+		Spec:       v1.ServiceSpec{ClusterIP: clusterIPs[0], ClusterIPs: clusterIPs},
+	}
+}
+
+func dnsEndpoints(port int32, ips ...string) *v1.Endpoints {
+	addresses := make([]v1.EndpointAddress, 0, len(ips))
+	for _, ip := range ips {
+		addresses = append(addresses, v1.EndpointAddress{IP: ip})
+	}
+	return &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-dns"},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: addresses,
+			Ports: []v1.EndpointPort{
+				{Name: "dns-tcp", Port: port, Protocol: v1.ProtocolTCP},
+				{Name: "dns", Port: port, Protocol: v1.ProtocolUDP},
+			},
+		}},
+	}
+}
+
+// waitForChange returns true when a change is notified before the timeout
+func waitForChange(d *ServiceDiscovery, timeout time.Duration) bool {
+	select {
+	case <-d.Changes():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestServiceDiscovery(t *testing.T) {
+	tests := []struct {
+		name         string
+		useEndpoints bool
+		objects      []runtime.Object
+		want         []string
+	}{
+		{
+			name:    "cluster IP",
+			objects: []runtime.Object{dnsService("10.96.0.10"), dnsEndpoints(53, "10.0.0.2")},
+			want:    []string{"10.96.0.10"},
+		},
+		{
+			name:    "dual stack",
+			objects: []runtime.Object{dnsService("10.96.0.10", "fd00::a")},
+			want:    []string{"10.96.0.10", "fd00::a"},
+		},
+		{
+			name:         "endpoints",
+			useEndpoints: true,
+			objects:      []runtime.Object{dnsService("10.96.0.10"), dnsEndpoints(53, "10.0.0.3", "10.0.0.2")},
+			want:         []string{"10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:         "endpoints port",
+			useEndpoints: true,
+			objects:      []runtime.Object{dnsEndpoints(1053, "10.0.0.2")},
+			want:         []string{"10.0.0.2@1053"},
+		},
+		{
+			name:    "headless falls back to the endpoints",
+			objects: []runtime.Object{dnsService("None"), dnsEndpoints(53, "10.0.0.2")},
+			want:    []string{"10.0.0.2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.objects...)
+			d := NewServiceDiscovery(client, "kube-system", "kube-dns", tt.useEndpoints)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			d.Start(stopCh)
+			if !d.WaitForSync(5 * time.Second) {
+				t.Fatal("WaitForSync() timed out")
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for !reflect.DeepEqual(d.Servers(), tt.want) && time.Now().Before(deadline) {
+				waitForChange(d, 100*time.Millisecond)
+			}
+			if got := d.Servers(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Servers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceDiscoveryChanges(t *testing.T) {
+	client := fake.NewSimpleClientset(dnsService("10.96.0.10"), dnsEndpoints(53, "10.0.0.2"))
+	d := NewServiceDiscovery(client, "kube-system", "kube-dns", false)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	d.Start(stopCh)
+	if !d.WaitForSync(5 * time.Second) {
+		t.Fatal("WaitForSync() timed out")
+	}
+	// drain the notifications of the initial state
+	for waitForChange(d, 200*time.Millisecond) {
+	}
+	if got := d.Servers(); !reflect.DeepEqual(got, []string{"10.96.0.10"}) {
+		t.Fatalf("Servers() = %v", got)
+	}
+
+	// the endpoints are not used while the ClusterIP is
+	_, err := client.CoreV1().Endpoints("kube-system").Update(context.Background(), dnsEndpoints(53, "10.0.0.2", "10.0.0.3"), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waitForChange(d, 500*time.Millisecond) {
+		t.Errorf("an endpoints change is notified while the ClusterIP is used")
+	}
+	if status := d.Status().(DiscoveredService); !reflect.DeepEqual(status.Endpoints, []string{"10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("Status().Endpoints = %v", status.Endpoints)
+	}
+
+	// without the Service the endpoints are used
+	if err := client.CoreV1().Services("kube-system").Delete(context.Background(), "kube-dns", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForChange(d, 5*time.Second) {
+		t.Fatal("the deleted Service is not notified")
+	}
+	if got := d.Servers(); !reflect.DeepEqual(got, []string{"10.0.0.2", "10.0.0.3"}) {
+		t.Errorf("Servers() = %v", got)
+	}
+}
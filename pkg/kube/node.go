@@ -0,0 +1,102 @@
+package kube
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// NodeWatcher watches the labels and selected annotations of a single Node
+type NodeWatcher struct {
+	client      kubernetes.Interface
+	name        string
+	annotations []string
+
+	lock            sync.RWMutex
+	nodeLabels      map[string]string
+	nodeAnnotations map[string]string
+	changes         chan struct{}
+	synced          cache.InformerSynced
+}
+
+// NewNodeWatcher returns a watcher for the named Node, changes are reported
+// for labels and for the listed annotation keys only
+func NewNodeWatcher(client kubernetes.Interface, name string, annotations ...string) *NodeWatcher {
+	return &NodeWatcher{
+		client:      client,
+		name:        name,
+		annotations: annotations,
+		changes:     make(chan struct{}, 1),
+	}
+}
+
+// Start watches the Node until stopCh is closed
+func (w *NodeWatcher) Start(stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(w.client, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.name).String()
+		}))
+
+	informer := factory.Core().V1().Nodes().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.onNode(obj) },
+		UpdateFunc: func(_, obj interface{}) { w.onNode(obj) },
+	})
+	w.synced = informer.HasSynced
+	factory.Start(stopCh)
+}
+
+// WaitForSync blocks until the Node is fetched or the timeout expires
+func (w *NodeWatcher) WaitForSync(timeout time.Duration) bool {
+	stopCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stopCh) })
+	defer timer.Stop()
+	return cache.WaitForCacheSync(stopCh, w.synced)
+}
+
+// Changes is notified every time the Node labels or watched annotations change
+func (w *NodeWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Labels returns the Node labels and watched annotations
+func (w *NodeWatcher) Labels() (map[string]string, map[string]string) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.nodeLabels, w.nodeAnnotations
+}
+
+func (w *NodeWatcher) onNode(obj interface{}) {
+	node, ok := obj.(*v1.Node)
+	if !ok || node.Name != w.name {
+		return
+	}
+	annotations := make(map[string]string)
+	for _, key := range w.annotations {
+		if value, ok := node.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+
+	w.lock.Lock()
+	changed := !reflect.DeepEqual(w.nodeLabels, node.Labels) || !reflect.DeepEqual(w.nodeAnnotations, annotations)
+	w.nodeLabels = node.Labels
+	w.nodeAnnotations = annotations
+	w.lock.Unlock()
+
+	if changed {
+		klog.V(2).Infof("Node %s labels changed: %v", w.name, node.Labels)
+		select {
+		case w.changes <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StatusAnnotation on the node-cache Pod holds its NodeCacheStatus
+const StatusAnnotation = "nodelocaldns/status"
+
+// NodeCacheStatus is the state of a node-cache instance, published on its Pod
+type NodeCacheStatus struct {
+	Node            string    `json:"node"`
+	ConfigVersion   string    `json:"configVersion"`
+	ConfigSource    string    `json:"configSource,omitempty"`
+	ResourceVersion string    `json:"resourceVersion,omitempty"`
+	UnboundVersion  string    `json:"unboundVersion,omitempty"`
+	LastReload      time.Time `json:"lastReload,omitempty"`
+	LastReloadError string    `json:"lastReloadError,omitempty"`
+}
+
+// StatusPublisher writes the node-cache status to the annotations of its Pod
+type StatusPublisher struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func NewStatusPublisher(client kubernetes.Interface, namespace, name string) *StatusPublisher {
+	return &StatusPublisher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// Publish replaces the status annotation of the Pod
+func (p *StatusPublisher) Publish(ctx context.Context, status NodeCacheStatus) error {
+	value, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{StatusAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.client.CoreV1().Pods(p.namespace).Patch(ctx, p.name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to publish status on Pod %s/%s: %v", p.namespace, p.name, err)
+	}
+	return nil
+}
+
+// ListStatus returns the status published by every node-cache Pod matching the selector,
+// Pods without a status are reported with the node name only
+func ListStatus(ctx context.Context, client kubernetes.Interface, namespace, selector string) ([]NodeCacheStatus, error) {
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]NodeCacheStatus, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		status := NodeCacheStatus{}
+		if value, ok := pod.Annotations[StatusAnnotation]; ok {
+			if err := json.Unmarshal([]byte(value), &status); err != nil {
+				status.LastReloadError = fmt.Sprintf("invalid status annotation: %v", err)
+			}
+		}
+		if status.Node == "" {
+			status.Node = pod.Spec.NodeName
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -17,11 +18,53 @@ var setupErrCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Help:      "The number of errors during periodic network setup for node-cache",
 }, []string{"errortype"})
 
-func InitMetrics(ipport string) error {
+var searchPathAvoidedCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "searchpath_queries_avoided_total",
+	Help:      "The number of search path queries answered with NXDOMAIN locally instead of being sent upstream",
+})
+
+var unboundRestartCount = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "unbound_restarts_total",
+	Help:      "The number of times unbound exited and was restarted by node-cache",
+})
+
+var cachePersistedEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "cache_persisted_entries",
+	Help:      "The number of cache entries in the last dump or load of the unbound cache",
+}, []string{"operation", "cache"})
+
+var cachePersistDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "cache_persist_duration_seconds",
+	Help:      "How long the last dump or load of the unbound cache took",
+}, []string{"operation"})
+
+var unboundLogLines = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "unbound_log_lines_total",
+	Help:      "The number of lines logged by unbound per unbound log level",
+}, []string{"level"})
+
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "unbound",
+	Subsystem: "nodecache",
+	Name:      "build_info",
+	Help:      "A metric with a constant '1' value labeled by the node-cache and the unbound versions",
+}, []string{"version", "unbound_version"})
+
+func InitMetrics(ipport string, controlSocket string) error {
 	if err := serveMetrics(ipport); err != nil {
 		return fmt.Errorf("Failed to start metrics handler: %s", err)
 	}
-	exporter := NewUnboundExporter("/var/run/unbound-control.sock")
+	exporter := NewUnboundExporter(controlSocket)
 	prometheus.MustRegister(exporter)
 
 	registerMetrics()
@@ -36,12 +79,45 @@ func registerMetrics() {
 	setupErrCount.WithLabelValues("interface_add").Add(0)
 	setupErrCount.WithLabelValues("interface_check").Add(0)
 	setupErrCount.WithLabelValues("config").Add(0)
+	prometheus.MustRegister(searchPathAvoidedCount)
+	prometheus.MustRegister(unboundRestartCount)
+	prometheus.MustRegister(cachePersistedEntries)
+	prometheus.MustRegister(cachePersistDuration)
+	prometheus.MustRegister(unboundLogLines)
+	prometheus.MustRegister(buildInfo)
+	for _, level := range []string{"fatal error", "error", "warning", "notice", "info", "debug", "query", "reply"} {
+		unboundLogLines.WithLabelValues(level).Add(0)
+	}
 }
 
 func PublishErrorMetric(label string) {
 	setupErrCount.WithLabelValues(label).Inc()
 }
 
+func PublishSearchPathAvoided() {
+	searchPathAvoidedCount.Inc()
+}
+
+func PublishUnboundRestart() {
+	unboundRestartCount.Inc()
+}
+
+// PublishCachePersistence records a dump or a load of the unbound cache
+func PublishCachePersistence(operation string, rrsets, messages int, duration time.Duration) {
+	cachePersistedEntries.WithLabelValues(operation, "rrset").Set(float64(rrsets))
+	cachePersistedEntries.WithLabelValues(operation, "message").Set(float64(messages))
+	cachePersistDuration.WithLabelValues(operation).Set(duration.Seconds())
+}
+
+func PublishUnboundLogLine(level string) {
+	unboundLogLines.WithLabelValues(level).Inc()
+}
+
+func PublishBuildInfo(version, unboundVersion string) {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, unboundVersion).Set(1)
+}
+
 func serveMetrics(ipport string) error {
 	ln, err := net.Listen("tcp", ipport)
 	if err != nil {
@@ -6,14 +6,17 @@ package metrics
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"net"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/hvoyvodov/nodelocaldns/pkg/control"
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/klog/v2"
 )
@@ -29,6 +32,16 @@ var (
 		"Query response time in seconds.",
 		nil, nil)
 
+	unboundAuthZoneSerialDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("unbound", "nodecache", "auth_zone_serial"),
+		"SOA serial of the locally held auth zone.",
+		[]string{"zone"}, nil)
+
+	unboundAuthZoneUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("unbound", "nodecache", "auth_zone_up"),
+		"Whether the auth zone is loaded and not expired.",
+		[]string{"zone"}, nil)
+
 	unboundMetrics = []*unboundMetric{
 		newUnboundMetric(
 			"answer_rcodes_total",
@@ -162,6 +175,24 @@ var (
 			prometheus.CounterValue,
 			[]string{"thread"},
 			"^thread([0-9]+)\\.requestlist\\.overwritten$"),
+		newUnboundMetric(
+			"query_authzone_total",
+			"Total number of queries answered from auth zones, for downstream (down) or upstream (up) lookups.",
+			prometheus.CounterValue,
+			[]string{"direction"},
+			"^num\\.query\\.authzone\\.(up|down)$"),
+		newUnboundMetric(
+			"queries_ip_ratelimited_total",
+			"Total number of queries dropped or truncated because the client IP was ratelimited.",
+			prometheus.CounterValue,
+			[]string{"thread"},
+			"^thread([0-9]+)\\.num\\.queries_ip_ratelimited$"),
+		newUnboundMetric(
+			"query_ratelimited_total",
+			"Total number of queries turned away because the zone was ratelimited.",
+			prometheus.CounterValue,
+			nil,
+			"^num\\.query\\.ratelimited$"),
 		newUnboundMetric(
 			"recursive_replies_total",
 			"Total number of replies sent to queries that needed recursive processing.",
@@ -238,9 +269,12 @@ type unboundMetric struct {
 }
 
 type UnboundExporter struct {
-	path string
+	client *control.Client
 }
 
+// scrapeTimeout bounds the remote-control commands of a scrape
+const scrapeTimeout = 5 * time.Second
+
 func newUnboundMetric(name string, description string, valueType prometheus.ValueType, labels []string, pattern string) *unboundMetric {
 	return &unboundMetric{
 		desc: prometheus.NewDesc(
@@ -331,39 +365,86 @@ func CollectFromReader(file io.Reader, ch chan<- prometheus.Metric) error {
 	return scanner.Err()
 }
 
-func CollectFromSocket(path string, ch chan<- prometheus.Metric) error {
-	var (
-		conn net.Conn
-		err  error
-	)
-
-	conn, err = net.Dial("unix", path)
-	if err != nil {
+// CollectFromClient collects the statistics through the remote-control
+func CollectFromClient(ctx context.Context, client *control.Client, ch chan<- prometheus.Metric) error {
+	var stats bytes.Buffer
+	if err := client.StatsTo(ctx, false, &stats); err != nil {
 		return err
 	}
-	defer conn.Close()
-	_, err = conn.Write([]byte("UBCT1 stats_noreset\n"))
-	if err != nil {
+	return CollectFromReader(&stats, ch)
+}
+
+// CollectAuthZonesFromReader parses the output of list_auth_zones,
+// lines in the form "<zone>\t<serial N|no serial|expired>"
+func CollectAuthZonesFromReader(file io.Reader, ch chan<- prometheus.Metric) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanLines)
+
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 2 {
+			return fmt.Errorf(
+				"%q is not a valid auth zone status",
+				scanner.Text())
+		}
+		zone, status := fields[0], fields[1]
+
+		up := 0.0
+		if strings.HasPrefix(status, "serial ") {
+			serial, err := strconv.ParseUint(strings.TrimPrefix(status, "serial "), 10, 32)
+			if err != nil {
+				return err
+			}
+			ch <- prometheus.MustNewConstMetric(
+				unboundAuthZoneSerialDesc,
+				prometheus.GaugeValue,
+				float64(serial),
+				zone)
+			up = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			unboundAuthZoneUpDesc,
+			prometheus.GaugeValue,
+			up,
+			zone)
+	}
+
+	return scanner.Err()
+}
+
+// CollectAuthZonesFromClient collects the auth zone status through the remote-control
+func CollectAuthZonesFromClient(ctx context.Context, client *control.Client, ch chan<- prometheus.Metric) error {
+	var zones bytes.Buffer
+	if err := client.ListAuthZonesTo(ctx, &zones); err != nil {
 		return err
 	}
-	return CollectFromReader(conn, ch)
+	return CollectAuthZonesFromReader(&zones, ch)
 }
 
 func NewUnboundExporter(path string) *UnboundExporter {
 	return &UnboundExporter{
-		path: path,
+		client: control.NewUnixClient(path),
 	}
 }
 
 func (e *UnboundExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- unboundUpDesc
+	ch <- unboundAuthZoneSerialDesc
+	ch <- unboundAuthZoneUpDesc
 	for _, metric := range unboundMetrics {
 		ch <- metric.desc
 	}
 }
 
 func (e *UnboundExporter) Collect(ch chan<- prometheus.Metric) {
-	err := CollectFromSocket(e.path, ch)
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+	err := CollectFromClient(ctx, e.client, ch)
+	if err == nil {
+		if err := CollectAuthZonesFromClient(ctx, e.client, ch); err != nil {
+			klog.Errorf("Failed to scrape auth zones: %v", err)
+		}
+	}
 	if err == nil {
 		ch <- prometheus.MustNewConstMetric(
 			unboundUpDesc,
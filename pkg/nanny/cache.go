@@ -0,0 +1,219 @@
+package nanny
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// cacheDumpFile and cacheMetaFile are kept in the state directory
+	cacheDumpFile = "unbound-cache.dump"
+	cacheMetaFile = "unbound-cache.json"
+	// cacheTimeout bounds a dump or a load of the whole cache
+	cacheTimeout = 2 * time.Minute
+)
+
+// cacheMeta describes a cache dump
+type cacheMeta struct {
+	Time          time.Time `json:"time"`
+	ConfigVersion string    `json:"configVersion"`
+	RRSets        int       `json:"rrsets"`
+	Messages      int       `json:"messages"`
+}
+
+// DumpCache writes the unbound cache to the state directory, it does
+// nothing unless the cache is persisted
+func (n *Nanny) DumpCache() error {
+	if !n.opts.PersistCache {
+		return nil
+	}
+	n.dumpLock.Lock()
+	defer n.dumpLock.Unlock()
+	start := time.Now()
+	if err := os.MkdirAll(n.opts.StateDir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(n.opts.StateDir, cacheDumpFile)
+	f, err := os.Create(path + ".new")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path + ".new")
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	err = n.client.DumpCache(ctx, f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("dump_cache failed: %v", err)
+	}
+
+	meta, err := countCacheEntries(path + ".new")
+	if err != nil {
+		return fmt.Errorf("dump_cache failed: %v", err)
+	}
+	meta.Time = start
+	meta.ConfigVersion = n.ConfigVersion()
+
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// the dump is renamed last: a dump older than its meta is not loaded
+	metaPath := filepath.Join(n.opts.StateDir, cacheMetaFile)
+	defer os.Remove(metaPath + ".new")
+	if err := os.WriteFile(metaPath+".new", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(metaPath+".new", metaPath); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".new", path); err != nil {
+		return err
+	}
+
+	metrics.PublishCachePersistence("dump", meta.RRSets, meta.Messages, time.Since(start))
+	klog.V(1).Infof("Dumped %d rrsets and %d messages of the unbound cache in %v", meta.RRSets, meta.Messages, time.Since(start))
+	return nil
+}
+
+// dumpCachePeriodically dumps the cache every CacheDumpInterval until the
+// nanny is stopped
+func (n *Nanny) dumpCachePeriodically() {
+	ticker := time.NewTicker(n.opts.CacheDumpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+		if err := n.DumpCache(); err != nil {
+			klog.Warningf("unable to persist the unbound cache: %v", err)
+		}
+	}
+}
+
+// restoreCache loads the dumped cache into a freshly started unbound, when
+// the dump is recent enough and made with the same configuration
+func (n *Nanny) restoreCache() {
+	data, err := os.ReadFile(filepath.Join(n.opts.StateDir, cacheMetaFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Warningf("unable to read the unbound cache dump: %v", err)
+		}
+		return
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		klog.Warningf("unable to read the unbound cache dump: %v", err)
+		return
+	}
+	if err := n.checkCacheDump(meta); err != nil {
+		klog.V(1).Infof("Not loading the unbound cache: %v", err)
+		return
+	}
+
+	if err := n.waitForControl(cacheTimeout); err != nil {
+		klog.Warningf("unable to load the unbound cache: %v", err)
+		return
+	}
+
+	start := time.Now()
+	f, err := os.Open(filepath.Join(n.opts.StateDir, cacheDumpFile))
+	if err != nil {
+		klog.Warningf("unable to load the unbound cache: %v", err)
+		return
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	if err := n.client.LoadCache(ctx, f); err != nil {
+		klog.Warningf("load_cache failed: %v", err)
+		return
+	}
+
+	metrics.PublishCachePersistence("load", meta.RRSets, meta.Messages, time.Since(start))
+	klog.V(0).Infof("Loaded %d rrsets and %d messages into the unbound cache in %v", meta.RRSets, meta.Messages, time.Since(start))
+}
+
+// checkCacheDump returns why the dump described by meta is not loaded: it
+// is older than CacheMaxAge, made with another configuration, or older than
+// meta when DumpCache did not complete
+func (n *Nanny) checkCacheDump(meta cacheMeta) error {
+	if age := time.Since(meta.Time); age > n.opts.CacheMaxAge {
+		return fmt.Errorf("dumped %v ago", age.Round(time.Second))
+	}
+	if version := n.ConfigVersion(); meta.ConfigVersion != version {
+		return fmt.Errorf("dumped with configuration %q, active is %q", meta.ConfigVersion, version)
+	}
+	info, err := os.Stat(filepath.Join(n.opts.StateDir, cacheDumpFile))
+	if err != nil {
+		return err
+	}
+	// truncated for the file systems keeping seconds only
+	if info.ModTime().Before(meta.Time.Truncate(time.Second)) {
+		return fmt.Errorf("the dump of %v is older than its metadata", info.ModTime().Round(time.Second))
+	}
+	return nil
+}
+
+// waitForControl waits until the remote-control of a starting unbound answers
+func (n *Nanny) waitForControl(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := n.client.Status(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("remote-control is not available: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// countCacheEntries counts the rrsets and messages of a dump_cache output,
+// which has to be complete up to its EOF line
+func countCacheEntries(path string) (cacheMeta, error) {
+	var meta cacheMeta
+	f, err := os.Open(path)
+	if err != nil {
+		return meta, err
+	}
+	defer f.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, ";rrset "):
+			meta.RRSets++
+		case strings.HasPrefix(line, "msg "):
+			meta.Messages++
+		case strings.HasPrefix(line, "error"):
+			return meta, fmt.Errorf("%s", line)
+		}
+		last = line
+	}
+	if err := scanner.Err(); err != nil {
+		return meta, err
+	}
+	if last != "EOF" {
+		return meta, fmt.Errorf("the dump is incomplete")
+	}
+	return meta, nil
+}
@@ -0,0 +1,110 @@
+package nanny
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckCacheDump(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		meta     cacheMeta
+		dumpTime time.Time
+		noDump   bool
+		wantErr  bool
+	}{
+		{
+			name:     "recent",
+			meta:     cacheMeta{Time: now.Add(-10 * time.Minute), ConfigVersion: "v1"},
+			dumpTime: now.Add(-10 * time.Minute),
+		},
+		{
+			name:     "older than CacheMaxAge",
+			meta:     cacheMeta{Time: now.Add(-2 * time.Hour), ConfigVersion: "v1"},
+			dumpTime: now.Add(-2 * time.Hour),
+			wantErr:  true,
+		},
+		{
+			name:     "other configuration",
+			meta:     cacheMeta{Time: now.Add(-10 * time.Minute), ConfigVersion: "v0"},
+			dumpTime: now.Add(-10 * time.Minute),
+			wantErr:  true,
+		},
+		{
+			name:     "dump older than the metadata",
+			meta:     cacheMeta{Time: now.Add(-10 * time.Minute), ConfigVersion: "v1"},
+			dumpTime: now.Add(-40 * time.Minute),
+			wantErr:  true,
+		},
+		{
+			name:    "no dump",
+			meta:    cacheMeta{Time: now.Add(-10 * time.Minute), ConfigVersion: "v1"},
+			noDump:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir := t.TempDir()
+			if !tt.noDump {
+				path := filepath.Join(stateDir, cacheDumpFile)
+				if err := os.WriteFile(path, []byte("EOF\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, tt.dumpTime, tt.dumpTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n := NewNanny(&RunNannyOpts{StateDir: stateDir, CacheMaxAge: time.Hour})
+			n.activeVersion = "v1"
+			if err := n.checkCacheDump(tt.meta); (err != nil) != tt.wantErr {
+				t.Errorf("checkCacheDump() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCountCacheEntries(t *testing.T) {
+	tests := []struct {
+		name         string
+		dump         string
+		wantRRSets   int
+		wantMessages int
+		wantErr      bool
+	}{
+		{
+			name:         "complete",
+			dump:         "START_RRSET_CACHE\n;rrset 3600 1 0 2 0\nexample.com.\t3600\tIN\tA\t192.0.2.1\nEND_RRSET_CACHE\nSTART_MSG_CACHE\nmsg example.com. IN A 33152 1 3600 0 1 0 0\nexample.com. IN A 0\nEND_MSG_CACHE\nEOF\n",
+			wantRRSets:   1,
+			wantMessages: 1,
+		},
+		{
+			name:    "incomplete",
+			dump:    "START_RRSET_CACHE\n;rrset 3600 1 0 2 0\n",
+			wantErr: true,
+		},
+		{
+			name:    "error",
+			dump:    "error out of memory\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), cacheDumpFile)
+			if err := os.WriteFile(path, []byte(tt.dump), 0644); err != nil {
+				t.Fatal(err)
+			}
+			meta, err := countCacheEntries(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("countCacheEntries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (meta.RRSets != tt.wantRRSets || meta.Messages != tt.wantMessages) {
+				t.Errorf("countCacheEntries() = %+v, want %d rrsets and %d messages", meta, tt.wantRRSets, tt.wantMessages)
+			}
+		})
+	}
+}
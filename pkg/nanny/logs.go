@@ -0,0 +1,159 @@
+package nanny
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// unboundLogPattern matches "[1697600000] unbound[1234:0] info: message" and
+// the log-time-ascii form "Oct 19 00:00:00 unbound[1234:0] info: message"
+var unboundLogPattern = regexp.MustCompile(`^(?:\[(\d+)\]|([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d)) \S+\[(\d+):(\w+)\] ([a-z]+(?: error)?): (.*)$`)
+
+// logRecord is a parsed unbound log line
+type logRecord struct {
+	Time    time.Time
+	PID     int
+	Thread  string
+	Level   string
+	Message string
+}
+
+// queryRecord is a log-queries or log-replies line, written to the query log
+type queryRecord struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Thread   string    `json:"thread"`
+	Client   string    `json:"client,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	QType    string    `json:"qtype,omitempty"`
+	Class    string    `json:"class,omitempty"`
+	Rcode    string    `json:"rcode,omitempty"`
+	Duration string    `json:"duration,omitempty"`
+	Cached   bool      `json:"cached,omitempty"`
+	Size     int       `json:"size,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+func parseLogLine(line string) (logRecord, bool) {
+	m := unboundLogPattern.FindStringSubmatch(line)
+	if m == nil {
+		return logRecord{}, false
+	}
+	rec := logRecord{Thread: m[4], Level: m[5], Message: m[6]}
+	if m[1] != "" {
+		if ts, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			rec.Time = time.Unix(ts, 0)
+		}
+	}
+	rec.PID, _ = strconv.Atoi(m[3])
+	return rec, true
+}
+
+// logOutput logs every line of an unbound output stream until it is closed
+func (n *Nanny) logOutput(stream string, reader io.Reader) {
+	bufReader := bufio.NewReader(reader)
+	for {
+		line, err := bufReader.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			// the stderr tail explains exits, the query lines would crowd it out
+			if n.logLine(line) && stream == "stderr" {
+				n.recordStderr(line)
+			}
+		}
+		if err == io.EOF {
+			klog.V(2).Infof("Got EOF from %v", stream)
+			return
+		} else if err != nil {
+			klog.Errorf("Error reading from %v: %v", stream, err)
+			return
+		}
+	}
+}
+
+// logLine maps the unbound level of a line to a klog severity, and routes
+// the query and reply lines to the query log. It returns false for those.
+func (n *Nanny) logLine(line string) bool {
+	rec, ok := parseLogLine(line)
+	if !ok {
+		klog.V(1).Info(line)
+		return true
+	}
+	metrics.PublishUnboundLogLine(rec.Level)
+
+	switch rec.Level {
+	case "query", "reply":
+		n.logQuery(rec)
+		return false
+	case "fatal error", "error":
+		klog.ErrorS(nil, rec.Message, "source", "unbound", "level", rec.Level, "thread", rec.Thread)
+	case "warning":
+		// klog has no structured warnings
+		klog.Warningf("unbound thread %s: %s", rec.Thread, rec.Message)
+	case "notice":
+		klog.InfoS(rec.Message, "source", "unbound", "level", rec.Level, "thread", rec.Thread)
+	case "info":
+		n.countLocalAction(rec.Message)
+		klog.V(1).InfoS(rec.Message, "source", "unbound", "level", rec.Level, "thread", rec.Thread)
+	default:
+		klog.V(3).InfoS(rec.Message, "source", "unbound", "level", rec.Level, "thread", rec.Thread)
+	}
+	return true
+}
+
+// logQuery writes a query or reply line, like "10.0.0.1 example.com. A IN"
+// followed for replies by "NOERROR 0.000123 0 45"
+func (n *Nanny) logQuery(rec logRecord) {
+	if n.opts.QueryLog == nil {
+		klog.V(1).InfoS(rec.Message, "source", "unbound", "level", rec.Level, "thread", rec.Thread)
+		return
+	}
+
+	q := queryRecord{Time: rec.Time, Type: rec.Level, Thread: rec.Thread}
+	if q.Time.IsZero() {
+		q.Time = time.Now()
+	}
+	fields := strings.Fields(rec.Message)
+	switch {
+	case rec.Level == "query" && len(fields) == 4:
+		q.Client, q.Name, q.QType, q.Class = fields[0], fields[1], fields[2], fields[3]
+	case rec.Level == "reply" && len(fields) == 8:
+		q.Client, q.Name, q.QType, q.Class = fields[0], fields[1], fields[2], fields[3]
+		q.Rcode, q.Duration = fields[4], fields[5]+"s"
+		q.Cached = fields[6] == "1"
+		q.Size, _ = strconv.Atoi(fields[7])
+	default:
+		q.Message = rec.Message
+	}
+
+	n.queryLogLock.Lock()
+	defer n.queryLogLock.Unlock()
+	if err := json.NewEncoder(n.opts.QueryLog).Encode(q); err != nil {
+		klog.V(1).Infof("unable to write the query log: %v", err)
+	}
+}
+
+// countLocalAction counts the queries answered by the search path zones,
+// logged by log-local-actions as "<zone> always_nxdomain <client> <qname> <type> <class>"
+func (n *Nanny) countLocalAction(message string) {
+	i := strings.Index(message, " always_nxdomain ")
+	if i < 0 {
+		return
+	}
+	fields := strings.Fields(message[:i])
+	if len(fields) == 0 {
+		return
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	if n.searchPathZones[fields[len(fields)-1]] {
+		metrics.PublishSearchPathAvoided()
+	}
+}
@@ -0,0 +1,124 @@
+package nanny
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   logRecord
+		wantOK bool
+	}{
+		{
+			name:   "timestamp",
+			line:   "[1697673600] unbound[1234:0] info: start of service (unbound 1.19.1).",
+			want:   logRecord{Time: time.Unix(1697673600, 0), PID: 1234, Thread: "0", Level: "info", Message: "start of service (unbound 1.19.1)."},
+			wantOK: true,
+		},
+		{
+			name:   "ascii time",
+			line:   "Oct 19 00:00:00 unbound[1234:1] warning: so-rcvbuf 4194304 was not granted",
+			want:   logRecord{PID: 1234, Thread: "1", Level: "warning", Message: "so-rcvbuf 4194304 was not granted"},
+			wantOK: true,
+		},
+		{
+			name:   "fatal error",
+			line:   "[1697673600] unbound[1234:0] fatal error: could not open ports",
+			want:   logRecord{Time: time.Unix(1697673600, 0), PID: 1234, Thread: "0", Level: "fatal error", Message: "could not open ports"},
+			wantOK: true,
+		},
+		{
+			name:   "query",
+			line:   "[1697673600] unbound[1234:0] query: 10.0.0.1 example.com. A IN",
+			want:   logRecord{Time: time.Unix(1697673600, 0), PID: 1234, Thread: "0", Level: "query", Message: "10.0.0.1 example.com. A IN"},
+			wantOK: true,
+		},
+		{
+			name: "not an unbound line",
+			line: "/etc/unbound/unbound.conf:12: error: syntax error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogLine(tt.line)
+			if ok != tt.wantOK {
+				t.Fatalf("parseLogLine() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !got.Time.Equal(tt.want.Time) || got.PID != tt.want.PID || got.Thread != tt.want.Thread ||
+				got.Level != tt.want.Level || got.Message != tt.want.Message {
+				t.Errorf("parseLogLine() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		want     string
+		wantLine bool
+	}{
+		{
+			name: "query",
+			line: "[1697673600] unbound[1234:0] query: 10.0.0.1 example.com. A IN",
+			want: `{"time":"2023-10-19T00:00:00Z","type":"query","thread":"0","client":"10.0.0.1","name":"example.com.","qtype":"A","class":"IN"}`,
+		},
+		{
+			name: "reply",
+			line: "[1697673600] unbound[1234:1] reply: 10.0.0.1 example.com. AAAA IN NOERROR 0.000123 1 45",
+			want: `{"time":"2023-10-19T00:00:00Z","type":"reply","thread":"1","client":"10.0.0.1","name":"example.com.","qtype":"AAAA","class":"IN","rcode":"NOERROR","duration":"0.000123s","cached":true,"size":45}`,
+		},
+		{
+			name: "unknown format",
+			line: "[1697673600] unbound[1234:0] reply: 10.0.0.1 example.com.",
+			want: `{"time":"2023-10-19T00:00:00Z","type":"reply","thread":"0","message":"10.0.0.1 example.com."}`,
+		},
+		{
+			name:     "other levels are not query lines",
+			line:     "[1697673600] unbound[1234:0] notice: init module 0: validator",
+			wantLine: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queryLog bytes.Buffer
+			n := NewNanny(&RunNannyOpts{QueryLog: &queryLog})
+			if got := n.logLine(tt.line); got != tt.wantLine {
+				t.Errorf("logLine() = %v, want %v", got, tt.wantLine)
+			}
+			got := strings.TrimSuffix(queryLog.String(), "\n")
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("query log = %s, want nothing", got)
+				}
+				return
+			}
+			// compare the values, the time is written in the local zone
+			var gotValues, wantValues map[string]interface{}
+			if err := json.Unmarshal([]byte(got), &gotValues); err != nil {
+				t.Fatalf("query log %q: %v", got, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantValues); err != nil {
+				t.Fatal(err)
+			}
+			gotTime, _ := time.Parse(time.RFC3339, gotValues["time"].(string))
+			wantTime, _ := time.Parse(time.RFC3339, wantValues["time"].(string))
+			if !gotTime.Equal(wantTime) {
+				t.Errorf("query log time = %v, want %v", gotTime, wantTime)
+			}
+			delete(gotValues, "time")
+			delete(wantValues, "time")
+			if !reflect.DeepEqual(gotValues, wantValues) {
+				t.Errorf("query log = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,148 +1,620 @@
 package nanny
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
-	"html/template"
 	"io"
 	"net"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+	"github.com/hvoyvodov/nodelocaldns/pkg/control"
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
 	"github.com/hvoyvodov/nodelocaldns/pkg/metrics"
-	"github.com/hvoyvodov/nodelocaldns/pkg/util"
 	"k8s.io/klog/v2"
 )
 
 type RunNannyOpts struct {
-	Exec            string
-	CheckExec       string
-	LocalIPs        []net.IP
-	LocalPort       int
-	Pid             string
-	Template        *template.Template
+	Exec      string
+	CheckExec string
+	LocalIPs  []net.IP
+	LocalPort int
+	Pid       string
+	StateDir  string
+	Template  *template.Template
+	// TemplateSource is the text of Template, to locate checkconf errors in it
+	TemplateSource  string
 	RestartOnChange bool
+	// RestartBackoff is the first delay before unbound is restarted, doubled
+	// on every consecutive failure up to MaxRestartBackoff
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+	// MaxRestarts consecutive failures give up, unbound running for
+	// RestartResetAfter is no failure anymore
+	MaxRestarts       int
+	RestartResetAfter time.Duration
+	// PersistCache dumps the cache to StateDir every CacheDumpInterval and
+	// loads dumps younger than CacheMaxAge after unbound starts
+	PersistCache      bool
+	CacheDumpInterval time.Duration
+	CacheMaxAge       time.Duration
+	Resources         ResourceOpts
+	// QueryLog receives the query and reply lines of log-queries and
+	// log-replies as JSON, they are logged with klog when nil
+	QueryLog io.Writer
 }
 
+// stderrTailLines is the number of stderr lines kept to report why unbound exited
+const stderrTailLines = 10
+
 type Nanny struct {
 	args        []string
 	cmd         *exec.Cmd
 	ExitChannel chan error
 	opts        *RunNannyOpts
+
+	lock            sync.RWMutex
+	searchPathZones map[string]bool
+	activeVersion   string
+	// checkconfErr is the last rejection by unbound-checkconf
+	checkconfErr *config.CheckconfError
+
+	versionOnce sync.Once
+	build       config.UnboundBuild
+
+	queryLogLock sync.Mutex
+	// dumpLock serialises the cache dumps, which share the temporary file
+	dumpLock sync.Mutex
+
+	client    *control.Client
+	resources ResourceStatus
+	readiness *readiness
+
+	procLock   sync.Mutex
+	stderrTail []string
+	restarts   int
+	// gaveUp is set once MaxRestarts consecutive failures stop the restarts
+	gaveUp   error
+	lastExit *UnboundExit
+
+	// stopCh is closed by Stop, stopped once supervise returned
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	stopped  chan struct{}
 }
 
 func NewNanny(opts *RunNannyOpts) *Nanny {
 	return &Nanny{
-		opts: opts,
+		opts:      opts,
+		client:    control.NewUnixClient(config.UnboundControlSocket),
+		readiness: newReadiness(),
+		stopCh:    make(chan struct{}),
+		stopped:   make(chan struct{}),
 	}
 }
 
-func (n *Nanny) Configure(c *config.Config) {
+// Configure renders the configuration and replaces the active unbound
+// configuration with it once unbound-checkconf accepts it. The active
+// configuration is left untouched when an error is returned.
+func (n *Nanny) Configure(c *config.Config) error {
+	n.prepare(c)
 
-	c.Port = 53
-	if n.opts.LocalPort > 0 && n.opts.LocalPort < 65535 {
-		c.Port = n.opts.LocalPort
+	if err := c.ValidateFor(c.Unbound); err != nil {
+		klog.Errorf("configuration is not supported by the unbound binary: %v", err)
+		metrics.PublishErrorMetric("config")
+		events.Warning(events.ReasonConfigRejected, "Configuration is not supported by unbound %s: %v", c.Unbound.Version, err)
+		return err
+	}
+
+	if len(c.AuthZones) > 0 || c.SearchPathScriptPath() != "" {
+		if err := os.MkdirAll(c.StateDir, 0755); err != nil {
+			klog.Errorf("unable to create state directory %s: %v", c.StateDir, err)
+			metrics.PublishErrorMetric("config")
+		}
+	}
+	if err := writeSearchPathScript(c); err != nil {
+		klog.Errorf("unable to write the search path script: %v", err)
+		metrics.PublishErrorMetric("config")
+		return err
 	}
-	c.Interfaces = n.opts.LocalIPs
-	c.Pid = n.opts.Pid
 
-	f, err := os.Create(config.UnboundConfigPath)
+	pending := config.UnboundConfigPath + ".new"
+	f, err := os.Create(pending)
 	if err != nil {
 		klog.Errorf("unable to create Unbound configuration %v", err)
 		metrics.PublishErrorMetric("config")
-		return
+		return err
 	}
-	defer f.Close()
+	defer os.Remove(pending)
 
 	err = n.opts.Template.Execute(f, c)
+	f.Close()
 	if err != nil {
 		klog.Errorf("unable to template Unbound configuration %v", err)
 		metrics.PublishErrorMetric("config")
+		return err
+	}
+
+	err = n.validate(pending)
+	n.recordCheckconf(err)
+	if err != nil {
+		klog.Errorf("rendered configuration is rejected by unbound-checkconf, keeping the active one: %v", err)
+		metrics.PublishErrorMetric("config")
+		events.Warning(events.ReasonCheckconfFailed, "Rendered unbound configuration is rejected, keeping the active one: %v", err)
+		return err
+	}
+
+	if err := os.Rename(pending, config.UnboundConfigPath); err != nil {
+		klog.Errorf("unable to replace Unbound configuration %v", err)
+		metrics.PublishErrorMetric("config")
+		return err
+	}
+
+	n.lock.Lock()
+	n.activeVersion = c.Version
+	n.searchPathZones = make(map[string]bool)
+	for _, zone := range c.SearchPathZones() {
+		n.searchPathZones[zone] = true
+	}
+	if c.SearchPathScriptPath() != "" {
+		n.searchPathZones[c.SearchPathSuffix()] = true
+	}
+	n.lock.Unlock()
+
+	return nil
+}
+
+// Check renders the configuration to a temporary file and validates it
+// with unbound-checkconf, the active configuration is left untouched
+func (n *Nanny) Check(c *config.Config) error {
+	n.prepare(c)
+	if err := c.ValidateFor(c.Unbound); err != nil {
+		return err
 	}
+	if c.SearchPathScriptPath() != "" {
+		if err := os.MkdirAll(c.StateDir, 0755); err != nil {
+			return err
+		}
+	}
+	if err := writeSearchPathScript(c); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp("", "unbound-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = n.opts.Template.Execute(f, c)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("unable to template Unbound configuration %v", err)
+	}
+	return n.validate(f.Name())
+}
+
+// prepare sets the runtime fields of the configuration
+func (n *Nanny) prepare(c *config.Config) {
+	c.Port = 53
+	if n.opts.LocalPort > 0 && n.opts.LocalPort < 65535 {
+		c.Port = n.opts.LocalPort
+	}
+	c.Interfaces = n.opts.LocalIPs
+	c.Pid = n.opts.Pid
+	c.StateDir = n.opts.StateDir
+	c.ControlInterface = config.UnboundControlSocket
+	c.Unbound = n.Build()
+}
+
+// writeSearchPathScript writes the python module limiting the labels under
+// svc.<domain>, the template refers to it when the limit is set
+func writeSearchPathScript(c *config.Config) error {
+	path := c.SearchPathScriptPath()
+	if path == "" {
+		return nil
+	}
+	return os.WriteFile(path, []byte(c.SearchPathScript()), 0644)
+}
+
+// recordCheckconf keeps the located checkconf errors for the status
+func (n *Nanny) recordCheckconf(err error) {
+	checkconfErr, _ := err.(*config.CheckconfError)
+	n.lock.Lock()
+	n.checkconfErr = checkconfErr
+	n.lock.Unlock()
+}
+
+// Reload applies the active configuration through the remote-control,
+// keeping the cache on unbound 1.18 and later. A plain reload is used when
+// reload_keep_cache is refused, and SIGHUP, which flushes the cache too, when
+// the remote-control cannot be reached.
+func (n *Nanny) Reload() error {
+	keepCache := n.Build().AtLeast("1.18.0")
+	klog.V(2).Infof("Reloading unbound, keeping the cache: %v", keepCache)
+
+	ctx, cancel := context.WithTimeout(context.Background(), reloadTimeout)
+	defer cancel()
+	err := n.client.Reload(ctx, keepCache)
+	if keepCache && control.IsReplyError(err) {
+		klog.Warningf("unbound refused to reload keeping the cache, reloading: %v", err)
+		err = n.client.Reload(ctx, false)
+	}
+	if control.IsDialError(err) {
+		klog.Warningf("unbound remote-control is not reachable, reloading with SIGHUP: %v", err)
+		return n.signalReload()
+	}
+	if err != nil {
+		klog.Errorf("unable to reload unbound %v", err)
+		events.Warning(events.ReasonReloadFailed, "Unable to reload unbound: %v", err)
+		return err
+	}
+	return nil
 }
 
-func (n *Nanny) Reload() {
-	klog.V(2).Infof("Reloading unbound")
-	if err := syscall.Kill(n.cmd.Process.Pid, syscall.SIGHUP); err != nil {
-		klog.Error("unable to reload unbound %v", err)
+func (n *Nanny) signalReload() error {
+	n.procLock.Lock()
+	cmd := n.cmd
+	n.procLock.Unlock()
+	if cmd == nil {
+		return fmt.Errorf("unbound is not running")
+	}
+	if err := syscall.Kill(cmd.Process.Pid, syscall.SIGHUP); err != nil {
+		klog.Errorf("unable to reload unbound %v", err)
+		events.Warning(events.ReasonReloadFailed, "Unable to reload unbound: %v", err)
+		return err
 	}
+	return nil
 }
 
+// reloadTimeout bounds a reload, which reads the configuration and auth-zone files
+const reloadTimeout = 30 * time.Second
+
+// Start validates the configuration and starts unbound under supervision.
+// ExitChannel receives the last exit status once unbound is crash looping.
 func (n *Nanny) Start() error {
 
-	if err := n.validate(); err != nil {
+	if err := n.validate(config.UnboundConfigPath); err != nil {
 		klog.Warningf("configuration cannot be validated %v", err)
 		return err
 	}
 
 	klog.V(3).Info("configuration is validated")
 
-	n.args = append(n.args, "-d", "-c", config.UnboundConfigPath)
-
-	n.cmd = exec.Command(n.opts.Exec, n.args...)
-	stderrReader, err := n.cmd.StderrPipe()
+	removeStalePidfile(n.opts.Pid)
+	done, err := n.spawn()
 	if err != nil {
 		return err
 	}
 
-	stdoutReader, err := n.cmd.StdoutPipe()
+	n.ExitChannel = make(chan error, 1)
+	go n.supervise(done)
+	go n.probeReadiness()
+
+	if n.opts.PersistCache && n.opts.CacheDumpInterval > 0 {
+		go n.dumpCachePeriodically()
+	}
+
+	return nil
+}
+
+// spawn starts unbound, the returned channel receives its exit status
+func (n *Nanny) spawn() (<-chan error, error) {
+	args := append(append([]string{}, n.args...), "-d", "-c", config.UnboundConfigPath)
+
+	cmd := exec.Command(n.opts.Exec, args...)
+	stderrReader, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := n.cmd.Start(); err != nil {
-		return err
+	stdoutReader, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
 	}
 
-	logToGlog := func(stream string, reader io.Reader) {
-		bufReader := bufio.NewReader(reader)
-		for {
-			bytes, err := bufReader.ReadBytes('\n')
-			if len(bytes) > 0 {
-				klog.V(1).Infof("%v", string(bytes))
-			}
-			if err == io.EOF {
-				klog.V(1).Infof("%v", string(bytes))
-				klog.Warningf("Got EOF from %v", stream)
-				return
-			} else if err != nil {
-				klog.V(1).Infof("%v", string(bytes))
-				klog.Errorf("Error reading from %v: %v", stream, err)
-				return
-			}
-		}
+	// a Stop either sees this unbound or keeps it from starting
+	n.procLock.Lock()
+	if n.isStopping() {
+		n.procLock.Unlock()
+		return nil, fmt.Errorf("unbound is stopping")
+	}
+	n.raiseNoFileLimit()
+	if err := cmd.Start(); err != nil {
+		n.procLock.Unlock()
+		return nil, err
 	}
+	n.cmd = cmd
+	n.stderrTail = nil
+	n.procLock.Unlock()
+	n.readiness.set(StateStartup, "")
 
-	go logToGlog("stderr", stderrReader)
-	go logToGlog("stdout", stdoutReader)
+	go n.applyResourcesOnStart(cmd.Process.Pid)
+	if n.opts.PersistCache {
+		go n.restoreCache()
+	}
 
-	n.ExitChannel = make(chan error)
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		n.logOutput("stderr", stderrReader)
+	}()
 	go func() {
-		n.ExitChannel <- n.cmd.Wait()
+		defer readers.Done()
+		n.logOutput("stdout", stdoutReader)
 	}()
 
-	return nil
+	done := make(chan error, 1)
+	go func() {
+		// the output has to be read completely before waiting
+		readers.Wait()
+		done <- cmd.Wait()
+	}()
+
+	return done, nil
 }
 
-func (n *Nanny) validate() error {
-	cmd := exec.Command(n.opts.CheckExec, config.UnboundConfigPath)
-	klog.V(2).Infof("Validating configuration")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		klog.V(1).Info(stderr.String())
-		return err
+// restartBackoff is the delay before unbound is restarted, doubled on every
+// consecutive failure up to MaxRestartBackoff
+type restartBackoff struct {
+	opts     *RunNannyOpts
+	delay    time.Duration
+	failures int
+}
+
+func newRestartBackoff(opts *RunNannyOpts) *restartBackoff {
+	return &restartBackoff{opts: opts, delay: opts.RestartBackoff}
+}
+
+// next records an exit of unbound after running for uptime, and returns the
+// delay before it is restarted. An uptime of RestartResetAfter is no failure
+// and resets the delay, giveUp is true after MaxRestarts consecutive failures.
+func (b *restartBackoff) next(uptime time.Duration) (delay time.Duration, giveUp bool) {
+	if uptime >= b.opts.RestartResetAfter {
+		b.delay = b.opts.RestartBackoff
+		b.failures = 0
+	}
+	b.failures++
+	if b.failures > b.opts.MaxRestarts {
+		return 0, true
 	}
+	delay = b.delay
+	b.delay *= 2
+	if b.delay > b.opts.MaxRestartBackoff {
+		b.delay = b.opts.MaxRestartBackoff
+	}
+	return delay, false
+}
 
-	return nil
+// supervise restarts unbound when it exits, waiting with an exponential
+// backoff in between. Unbound running for RestartResetAfter resets the
+// backoff, and MaxRestarts consecutive failures give up through ExitChannel.
+func (n *Nanny) supervise(done <-chan error) {
+	defer close(n.stopped)
+	backoff := newRestartBackoff(n.opts)
+	started := time.Now()
+	for {
+		err := <-done
+		if n.isStopping() {
+			klog.V(0).Infof("unbound stopped after %v", time.Since(started).Round(time.Second))
+			return
+		}
+		exit := n.recordExit(err, started)
+		klog.Errorf("unbound exited: %s", exit)
+		n.readiness.set(StateDegraded, "unbound exited: "+exit.String())
+
+		delay, giveUp := backoff.next(time.Since(started))
+		if giveUp {
+			klog.Errorf("unbound failed %d times in a row, giving up", backoff.failures)
+			n.giveUp(exit)
+			return
+		}
+
+		klog.Warningf("Restarting unbound in %v (restart %d of %d)", delay, backoff.failures, n.opts.MaxRestarts)
+		events.Warning(events.ReasonUnboundRestarted, "unbound exited (%s), restarting in %v", exit, delay)
+		metrics.PublishUnboundRestart()
+		select {
+		case <-time.After(delay):
+		case <-n.stopCh:
+			return
+		}
+
+		started = time.Now()
+		if done, err = n.spawn(); err != nil {
+			klog.Errorf("unable to restart unbound: %v", err)
+			failed := make(chan error, 1)
+			failed <- err
+			done = failed
+		}
+	}
+}
+
+// giveUp stops the restarts, Healthz fails from then on and ExitChannel
+// receives the last exit
+func (n *Nanny) giveUp(exit UnboundExit) {
+	err := fmt.Errorf("unbound is crash looping, last exit: %s", exit)
+	n.procLock.Lock()
+	n.gaveUp = err
+	n.procLock.Unlock()
+	n.ExitChannel <- err
 }
 
+// Stop stops unbound without restarting it: it is sent SIGTERM, and SIGKILL
+// when it is still running after timeout
+func (n *Nanny) Stop(timeout time.Duration) error {
+	n.stopOnce.Do(func() { close(n.stopCh) })
+
+	n.procLock.Lock()
+	cmd := n.cmd
+	n.procLock.Unlock()
+	if cmd == nil {
+		return nil
+	}
+
+	klog.V(1).Infof("Stopping unbound (pid %d)", cmd.Process.Pid)
+	// signalling an exited process fails, supervise reports it as stopped
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-n.stopped:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	klog.Warningf("unbound did not stop within %v, killing it", timeout)
+	cmd.Process.Kill()
+	select {
+	case <-n.stopped:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("unbound (pid %d) did not exit after SIGKILL", cmd.Process.Pid)
+	}
+}
+
+func (n *Nanny) isStopping() bool {
+	select {
+	case <-n.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// UnboundExit describes how unbound exited
+type UnboundExit struct {
+	Time     time.Time     `json:"time"`
+	Uptime   time.Duration `json:"uptime"`
+	ExitCode int           `json:"exitCode"`
+	Signal   string        `json:"signal,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Stderr   []string      `json:"stderr,omitempty"`
+}
+
+func (e UnboundExit) String() string {
+	var status string
+	switch {
+	case e.Signal != "":
+		status = "killed by signal " + e.Signal
+	case e.Error != "":
+		status = e.Error
+	default:
+		status = fmt.Sprintf("exit code %d", e.ExitCode)
+	}
+	if len(e.Stderr) > 0 {
+		status += ": " + e.Stderr[len(e.Stderr)-1]
+	}
+	return status
+}
+
+// nannyStatus reports the state and the restarts of unbound, and why the
+// last configuration was rejected
+type nannyStatus struct {
+	State     UnboundState           `json:"state"`
+	Restarts  int                    `json:"restarts"`
+	LastExit  *UnboundExit           `json:"lastExit,omitempty"`
+	Checkconf *config.CheckconfError `json:"checkconf,omitempty"`
+}
+
+func (n *Nanny) recordExit(err error, started time.Time) UnboundExit {
+	exit := UnboundExit{Time: time.Now(), Uptime: time.Since(started).Round(time.Second), ExitCode: -1}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exit.ExitCode = exitErr.ExitCode()
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			exit.Signal = ws.Signal().String()
+		}
+	} else if err != nil {
+		exit.Error = err.Error()
+	} else {
+		exit.ExitCode = 0
+	}
+
+	n.procLock.Lock()
+	defer n.procLock.Unlock()
+	exit.Stderr = append([]string{}, n.stderrTail...)
+	n.restarts++
+	n.lastExit = &exit
+	return exit
+}
+
+// recordStderr keeps the last stderrTailLines lines unbound wrote to stderr
+func (n *Nanny) recordStderr(line string) {
+	n.procLock.Lock()
+	defer n.procLock.Unlock()
+	n.stderrTail = append(n.stderrTail, strings.TrimSpace(line))
+	if len(n.stderrTail) > stderrTailLines {
+		n.stderrTail = n.stderrTail[len(n.stderrTail)-stderrTailLines:]
+	}
+}
+
+// Healthz is the liveness of the nanny: unbound is supervised and restarted
+// while the restarts stay within MaxRestarts, even when it is not ready
 func (n *Nanny) Healthz() error {
-	if !util.IsFileExists(n.opts.Pid) {
-		return fmt.Errorf("pid file for unbound is not found")
+	n.procLock.Lock()
+	defer n.procLock.Unlock()
+	return n.gaveUp
+}
+
+// Status reports the state of unbound, how often it was restarted and why it last exited
+func (n *Nanny) Status() interface{} {
+	status := nannyStatus{State: n.readiness.get()}
+	n.lock.RLock()
+	status.Checkconf = n.checkconfErr
+	n.lock.RUnlock()
+	n.procLock.Lock()
+	defer n.procLock.Unlock()
+	status.Restarts, status.LastExit = n.restarts, n.lastExit
+	return status
+}
+
+// ConfigVersion returns the version of the configuration unbound-checkconf
+// accepted and unbound runs with
+func (n *Nanny) ConfigVersion() string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	return n.activeVersion
+}
+
+// Build returns the version and features reported by "unbound -V", detected once
+func (n *Nanny) Build() config.UnboundBuild {
+	n.versionOnce.Do(func() {
+		out, err := exec.Command(n.opts.Exec, "-V").Output()
+		if err != nil {
+			klog.Warningf("unable to detect unbound version: %v", err)
+			return
+		}
+		n.build = config.ParseUnboundBuild(string(out))
+		klog.V(1).Infof("unbound version %s with %v", n.build.Version, n.build.Features)
+	})
+	return n.build
+}
+
+// Version returns the version of unbound, empty when it is unknown
+func (n *Nanny) Version() string {
+	return n.Build().Version
+}
+
+// validate runs unbound-checkconf on a rendered configuration, its errors
+// are returned as a *config.CheckconfError located in the template
+func (n *Nanny) validate(path string) error {
+	cmd := exec.Command(n.opts.CheckExec, path)
+	klog.V(2).Infof("Validating configuration %s", path)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
 	}
-	return nil
+	klog.V(1).Info(string(output))
+	if _, ok := err.(*exec.ExitError); !ok {
+		return err
+	}
+
+	rendered, readErr := os.ReadFile(path)
+	if readErr != nil {
+		klog.Warningf("unable to read the rendered configuration: %v", readErr)
+	}
+	return config.ParseCheckconf(string(output), path, rendered, n.opts.TemplateSource)
 }
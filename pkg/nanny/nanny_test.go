@@ -0,0 +1,231 @@
+package nanny
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/config"
+)
+
+// fakeCheckconf writes a shell script standing in for unbound-checkconf,
+// which records its arguments next to itself
+func fakeCheckconf(t *testing.T, body string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "unbound-checkconf")
+	args := filepath.Join(dir, "args")
+	data := "#!/bin/sh\necho \"$@\" > " + args + "\n" + body
+	if err := os.WriteFile(script, []byte(data), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script, args
+}
+
+func TestValidate(t *testing.T) {
+	const tmpl = "server:\n  num-threads: {{ .NumThreads }}\n"
+	rendered := filepath.Join(t.TempDir(), "unbound.conf.new")
+	if err := os.WriteFile(rendered, []byte("server:\n  num-threads: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		body         string
+		wantErr      bool
+		wantProblems []config.CheckconfProblem
+	}{
+		{
+			name: "accepted",
+			body: "echo \"unbound-checkconf: no errors in $1\"\n",
+		},
+		{
+			name:    "rejected",
+			body:    "echo \"$1:2: error: num-threads: number must be positive\" >&2\necho \"read $1 failed: 1 errors in configuration file\" >&2\nexit 1\n",
+			wantErr: true,
+			wantProblems: []config.CheckconfProblem{{
+				Line:          2,
+				Message:       "error: num-threads: number must be positive",
+				Context:       []string{"    1 | server:", ">   2 |   num-threads: 0", "    3 | "},
+				TemplateLines: []int{2},
+				Fields:        []string{"numThreads"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, args := fakeCheckconf(t, tt.body)
+			n := NewNanny(&RunNannyOpts{CheckExec: script, TemplateSource: tmpl})
+			err := n.validate(rendered)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(args); string(got) != rendered+"\n" {
+				t.Errorf("unbound-checkconf arguments = %q, want %q", got, rendered)
+			}
+			if !tt.wantErr {
+				return
+			}
+			checkconfErr, ok := err.(*config.CheckconfError)
+			if !ok {
+				t.Fatalf("validate() error %T is not a *config.CheckconfError", err)
+			}
+			if !reflect.DeepEqual(checkconfErr.Problems, tt.wantProblems) {
+				t.Errorf("validate() problems = %#v, want %#v", checkconfErr.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestValidateMissingCheckconf(t *testing.T) {
+	n := NewNanny(&RunNannyOpts{CheckExec: filepath.Join(t.TempDir(), "unbound-checkconf")})
+	err := n.validate(filepath.Join(t.TempDir(), "unbound.conf.new"))
+	if err == nil {
+		t.Fatal("validate() succeeded without unbound-checkconf")
+	}
+	if _, ok := err.(*config.CheckconfError); ok {
+		t.Errorf("validate() error %v is taken for a rejection", err)
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	opts := &RunNannyOpts{
+		RestartBackoff:    time.Second,
+		MaxRestartBackoff: 5 * time.Second,
+		MaxRestarts:       5,
+		RestartResetAfter: time.Minute,
+	}
+	type exit struct {
+		uptime     time.Duration
+		wantDelay  time.Duration
+		wantGiveUp bool
+	}
+	tests := []struct {
+		name  string
+		exits []exit
+	}{
+		{
+			name: "doubled up to the maximum",
+			exits: []exit{
+				{time.Second, time.Second, false},
+				{time.Second, 2 * time.Second, false},
+				{time.Second, 4 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+			},
+		},
+		{
+			name: "reset after running long enough",
+			exits: []exit{
+				{time.Second, time.Second, false},
+				{time.Second, 2 * time.Second, false},
+				{time.Second, 4 * time.Second, false},
+				{time.Minute, time.Second, false},
+				{time.Second, 2 * time.Second, false},
+			},
+		},
+		{
+			name: "give up after MaxRestarts failures",
+			exits: []exit{
+				{time.Second, time.Second, false},
+				{time.Second, 2 * time.Second, false},
+				{time.Second, 4 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+				{time.Second, 0, true},
+			},
+		},
+		{
+			name: "a reset counts the failures again",
+			exits: []exit{
+				{time.Second, time.Second, false},
+				{time.Second, 2 * time.Second, false},
+				{time.Second, 4 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+				{time.Second, 5 * time.Second, false},
+				{time.Hour, time.Second, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRestartBackoff(opts)
+			for i, e := range tt.exits {
+				delay, giveUp := b.next(e.uptime)
+				if delay != e.wantDelay || giveUp != e.wantGiveUp {
+					t.Errorf("exit %d: next(%v) = %v, %v, want %v, %v", i+1, e.uptime, delay, giveUp, e.wantDelay, e.wantGiveUp)
+				}
+			}
+		})
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	n := NewNanny(&RunNannyOpts{})
+	n.ExitChannel = make(chan error, 1)
+	if err := n.Healthz(); err != nil {
+		t.Fatalf("Healthz() = %v before giving up", err)
+	}
+
+	n.giveUp(UnboundExit{ExitCode: 1})
+	if err := n.Healthz(); err == nil {
+		t.Errorf("Healthz() = nil after giving up")
+	}
+	select {
+	case err := <-n.ExitChannel:
+		if err != n.Healthz() {
+			t.Errorf("ExitChannel received %v, Healthz() = %v", err, n.Healthz())
+		}
+	default:
+		t.Errorf("ExitChannel did not receive the last exit")
+	}
+}
+
+// startIgnoringTerm starts a process ignoring SIGTERM as the unbound of n,
+// supervised like Start does
+func startIgnoringTerm(t *testing.T, n *Nanny) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", `trap "" TERM; echo ready; while :; do sleep 1; done`)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// SIGTERM is ignored once the trap is set
+	if _, err := bufio.NewReader(stdout).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	n.cmd = cmd
+	go func() {
+		cmd.Wait()
+		close(n.stopped)
+	}()
+	return cmd
+}
+
+func TestStopKills(t *testing.T) {
+	n := NewNanny(&RunNannyOpts{})
+	cmd := startIgnoringTerm(t, n)
+
+	start := time.Now()
+	if err := n.Stop(200 * time.Millisecond); err != nil {
+		t.Fatalf("Stop() = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Stop() killed unbound after %v, before the timeout", elapsed)
+	}
+	ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() || ws.Signal() != syscall.SIGKILL {
+		t.Errorf("unbound exited with %v, want SIGKILL", cmd.ProcessState)
+	}
+	if !n.isStopping() {
+		t.Errorf("Stop() does not stop the restarts")
+	}
+}
@@ -0,0 +1,188 @@
+package nanny
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
+	"k8s.io/klog/v2"
+)
+
+// States of unbound as seen through the remote-control
+const (
+	StateStartup  = "startup"
+	StateReady    = "ready"
+	StateDegraded = "degraded"
+	StateStopped  = "stopped"
+)
+
+const (
+	// readinessInterval is the period of the status probe once unbound is
+	// ready, startupInterval the one while it starts
+	readinessInterval = 5 * time.Second
+	startupInterval   = 500 * time.Millisecond
+	probeTimeout      = 2 * time.Second
+	// degradedAfter consecutive failed probes degrade a ready unbound
+	degradedAfter = 3
+)
+
+// readiness tracks the state of the running unbound
+type readiness struct {
+	lock     sync.RWMutex
+	state    string
+	reason   string
+	since    time.Time
+	failures int
+	// ready is closed the first time unbound is ready
+	readyOnce sync.Once
+	ready     chan struct{}
+}
+
+// UnboundState reports the state of unbound and why it is not ready
+type UnboundState struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+func newReadiness() *readiness {
+	return &readiness{state: StateStartup, since: time.Now(), ready: make(chan struct{})}
+}
+
+func (r *readiness) set(state, reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if state == StateReady {
+		r.failures = 0
+		r.readyOnce.Do(func() { close(r.ready) })
+	}
+	if r.state == state && r.reason == reason {
+		return
+	}
+	if r.state != state {
+		r.since = time.Now()
+	}
+	r.state, r.reason = state, reason
+}
+
+// fail records a failed probe and returns the resulting state
+func (r *readiness) fail(reason string) string {
+	r.lock.Lock()
+	r.failures++
+	state := r.state
+	if state == StateReady && r.failures < degradedAfter {
+		r.lock.Unlock()
+		return state
+	}
+	r.lock.Unlock()
+	if state == StateReady {
+		state = StateDegraded
+	}
+	r.set(state, reason)
+	return state
+}
+
+func (r *readiness) get() UnboundState {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return UnboundState{State: r.state, Reason: r.reason, Since: r.since}
+}
+
+// Ready is closed once unbound answers on the remote-control for the first time
+func (n *Nanny) Ready() <-chan struct{} {
+	return n.readiness.ready
+}
+
+// State returns the state of unbound
+func (n *Nanny) State() UnboundState {
+	return n.readiness.get()
+}
+
+// Readyz fails unless unbound answers on the remote-control as the process
+// the nanny started
+func (n *Nanny) Readyz() error {
+	s := n.readiness.get()
+	if s.State == StateReady {
+		return nil
+	}
+	if s.Reason != "" {
+		return fmt.Errorf("unbound is in %s state: %s", s.State, s.Reason)
+	}
+	return fmt.Errorf("unbound is in %s state", s.State)
+}
+
+// probeReadiness probes unbound through the status command until it is stopped
+func (n *Nanny) probeReadiness() {
+	for {
+		interval := readinessInterval
+		if n.readiness.get().State != StateReady {
+			interval = startupInterval
+		}
+		select {
+		case <-n.stopCh:
+			n.readiness.set(StateStopped, "")
+			return
+		case <-time.After(interval):
+		}
+		n.probe()
+	}
+}
+
+// probe checks that the status reply names the pid of the running unbound
+func (n *Nanny) probe() {
+	n.procLock.Lock()
+	cmd := n.cmd
+	n.procLock.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	status, err := n.client.Status(ctx)
+	if err == nil && status.PID != cmd.Process.Pid {
+		err = fmt.Errorf("remote-control is answered by pid %d instead of %d", status.PID, cmd.Process.Pid)
+	}
+	if err == nil {
+		if previous := n.readiness.get().State; previous != StateReady {
+			klog.V(0).Infof("unbound (pid %d) is ready", cmd.Process.Pid)
+		}
+		n.readiness.set(StateReady, "")
+		return
+	}
+
+	previous := n.readiness.get().State
+	if state := n.readiness.fail(err.Error()); state == StateDegraded && previous != StateDegraded {
+		klog.Errorf("unbound is degraded: %v", err)
+		events.Warning(events.ReasonUnboundDegraded, "unbound does not answer on the remote-control: %v", err)
+	}
+}
+
+// removeStalePidfile removes a pidfile left by an unbound which is not
+// running anymore, it would otherwise be taken for the new one
+func removeStalePidfile(path string) {
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && pid > 0 && syscall.Kill(pid, 0) == nil {
+		if comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); strings.TrimSpace(string(comm)) == "unbound" {
+			klog.Warningf("unbound from pidfile %s is still running as pid %d", path, pid)
+			return
+		}
+	}
+	klog.V(0).Infof("Removing stale pidfile %s", path)
+	if err := os.Remove(path); err != nil {
+		klog.Warningf("unable to remove stale pidfile %s: %v", path, err)
+	}
+}
@@ -0,0 +1,221 @@
+package nanny
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hvoyvodov/nodelocaldns/pkg/events"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// ResourceOpts are the resource controls applied to the unbound process
+type ResourceOpts struct {
+	// OOMScoreAdj is written to oom_score_adj when set, -1000 to 1000
+	OOMScoreAdj *int
+	// Nice is the scheduling priority, 0 keeps the inherited one
+	Nice int
+	// IOClass is the ionice class, 1 realtime, 2 best-effort and 3 idle, 0
+	// keeps the inherited one. IOPriority is the level within the class, 0 to 7.
+	IOClass    int
+	IOPriority int
+	// NoFileLimit raises the soft RLIMIT_NOFILE of the nanny before unbound is
+	// started, which inherits it. The hard limit is only raised when it is
+	// below, 0 or a value below the soft limit keeps the inherited limits.
+	NoFileLimit uint64
+	// CPUs pins unbound to the listed CPUs, empty keeps the inherited affinity
+	CPUs []int
+}
+
+// ioprio_set(2) constants, not provided by x/sys
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+// ResourceStatus records whether the resource controls are in effect
+type ResourceStatus struct {
+	lock sync.RWMutex
+	err  error
+}
+
+// ResourceState is the status of the resource controls
+type ResourceState struct {
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Status reports resource controls which could not be applied or verified.
+// They degrade unbound without making it unhealthy, so they are not a
+// liveness check.
+func (r *ResourceStatus) Status() interface{} {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.err != nil {
+		return ResourceState{Error: r.err.Error()}
+	}
+	return ResourceState{Applied: true}
+}
+
+// Resources returns the status provider of the resource controls
+func (n *Nanny) Resources() *ResourceStatus {
+	return &n.resources
+}
+
+// raiseNoFileLimit raises RLIMIT_NOFILE of the nanny for unbound to inherit.
+// unbound sizes its socket and outgoing query tables when it starts, a
+// limit raised on the running process is not used. syscall.Setrlimit is
+// used as os/exec passes the limit it sets on to the children.
+func (n *Nanny) raiseNoFileLimit() {
+	limit := n.opts.Resources.NoFileLimit
+	if limit == 0 {
+		return
+	}
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &current); err != nil {
+		klog.Errorf("unable to get RLIMIT_NOFILE: %v", err)
+		return
+	}
+	raised, ok := raisedNoFileLimit(current, limit)
+	if !ok {
+		klog.Warningf("RLIMIT_NOFILE %d is below the inherited soft limit %d, keeping it", limit, current.Cur)
+		return
+	}
+	if raised == current {
+		return
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &raised); err != nil {
+		klog.Errorf("unable to raise RLIMIT_NOFILE to %d: %v", limit, err)
+	}
+}
+
+// raisedNoFileLimit returns current with the soft limit raised to limit, and
+// the hard limit only when it is below. A limit below the soft limit is not
+// applied and returns false.
+func raisedNoFileLimit(current syscall.Rlimit, limit uint64) (syscall.Rlimit, bool) {
+	if limit < current.Cur {
+		return current, false
+	}
+	raised := syscall.Rlimit{Cur: limit, Max: current.Max}
+	if raised.Max < limit {
+		raised.Max = limit
+	}
+	return raised, true
+}
+
+// applyResourcesOnStart applies the resource controls right after unbound is
+// started and again once it answers, to cover the threads started in between
+func (n *Nanny) applyResourcesOnStart(pid int) {
+	n.applyResources(pid)
+	if err := n.waitForControl(30 * time.Second); err != nil {
+		klog.Warningf("unable to verify the resource controls of unbound: %v", err)
+		return
+	}
+	n.applyResources(pid)
+}
+
+// applyResources applies and verifies the resource controls on a freshly
+// started unbound. The per thread settings are applied to every thread, the
+// file limit is only verified.
+func (n *Nanny) applyResources(pid int) {
+	opts := n.opts.Resources
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	if opts.OOMScoreAdj != nil {
+		if err := setOOMScoreAdj(pid, *opts.OOMScoreAdj); err != nil {
+			fail("oom_score_adj: %v", err)
+		}
+	}
+
+	if opts.NoFileLimit > 0 {
+		// inherited from the nanny, see raiseNoFileLimit
+		var got unix.Rlimit
+		if err := unix.Prlimit(pid, unix.RLIMIT_NOFILE, nil, &got); err != nil {
+			fail("RLIMIT_NOFILE: %v", err)
+		} else if got.Cur < opts.NoFileLimit {
+			fail("RLIMIT_NOFILE is %d instead of %d", got.Cur, opts.NoFileLimit)
+		}
+	}
+
+	tids, err := threads(pid)
+	if err != nil {
+		fail("%v", err)
+	}
+	for _, tid := range tids {
+		if opts.Nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, opts.Nice); err != nil {
+				fail("nice of thread %d: %v", tid, err)
+			} else if prio, err := unix.Getpriority(unix.PRIO_PROCESS, tid); err != nil || 20-prio != opts.Nice {
+				// the raw syscall returns 20 - nice
+				fail("nice of thread %d is %d instead of %d", tid, 20-prio, opts.Nice)
+			}
+		}
+		if opts.IOClass != 0 {
+			prio := opts.IOClass<<ioprioClassShift | opts.IOPriority
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+				fail("ionice of thread %d: %v", tid, errno)
+			} else if got, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0); errno != 0 || int(got) != prio {
+				fail("ionice of thread %d is %d instead of %d", tid, got, prio)
+			}
+		}
+		if len(opts.CPUs) > 0 {
+			var set, got unix.CPUSet
+			for _, cpu := range opts.CPUs {
+				set.Set(cpu)
+			}
+			if err := unix.SchedSetaffinity(tid, &set); err != nil {
+				fail("CPU affinity of thread %d: %v", tid, err)
+			} else if err := unix.SchedGetaffinity(tid, &got); err != nil || got != set {
+				fail("CPU affinity of thread %d is not %v", tid, opts.CPUs)
+			}
+		}
+	}
+
+	n.resources.lock.Lock()
+	defer n.resources.lock.Unlock()
+	n.resources.err = nil
+	if len(errs) > 0 {
+		n.resources.err = fmt.Errorf("resource controls of unbound are not applied: %s", strings.Join(errs, "; "))
+		klog.Error(n.resources.err)
+		events.Warning(events.ReasonResourcesNotApplied, "%v", n.resources.err)
+	}
+}
+
+func setOOMScoreAdj(pid int, value int) error {
+	path := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := os.WriteFile(path, []byte(strconv.Itoa(value)), 0644); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if got := strings.TrimSpace(string(data)); got != strconv.Itoa(value) {
+		return fmt.Errorf("is %s instead of %d", got, value)
+	}
+	return nil
+}
+
+// threads lists the thread ids of a process
+func threads(pid int) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join("/proc", strconv.Itoa(pid), "task"))
+	if err != nil {
+		return nil, err
+	}
+	var tids []int
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	return tids, nil
+}
@@ -0,0 +1,41 @@
+package nanny
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestRaisedNoFileLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		current syscall.Rlimit
+		limit   uint64
+		want    syscall.Rlimit
+		wantOK  bool
+	}{
+		{"soft limit only", syscall.Rlimit{Cur: 1024, Max: 524288}, 65536, syscall.Rlimit{Cur: 65536, Max: 524288}, true},
+		{"hard limit below", syscall.Rlimit{Cur: 1024, Max: 4096}, 65536, syscall.Rlimit{Cur: 65536, Max: 65536}, true},
+		{"same limit", syscall.Rlimit{Cur: 65536, Max: 65536}, 65536, syscall.Rlimit{Cur: 65536, Max: 65536}, true},
+		{"below the soft limit", syscall.Rlimit{Cur: 65536, Max: 524288}, 1024, syscall.Rlimit{Cur: 65536, Max: 524288}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := raisedNoFileLimit(tt.current, tt.limit)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("raisedNoFileLimit() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestResourceStatus(t *testing.T) {
+	var r ResourceStatus
+	if got := r.Status(); got != (ResourceState{Applied: true}) {
+		t.Errorf("Status() = %+v", got)
+	}
+	r.err = errors.New("nice of thread 1: permission denied")
+	if got := r.Status(); got != (ResourceState{Error: "nice of thread 1: permission denied"}) {
+		t.Errorf("Status() = %+v", got)
+	}
+}
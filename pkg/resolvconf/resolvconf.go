@@ -0,0 +1,59 @@
+package resolvconf
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// ResolvConf holds the settings of a resolv.conf(5) file
+type ResolvConf struct {
+	Nameservers []string
+	Search      []string
+	Options     []string
+}
+
+// ParseFile reads the resolv.conf at path
+func ParseFile(path string) (*ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads a resolv.conf, nameservers which are not IP addresses are
+// skipped and the last of the search and domain keywords wins
+func Parse(r io.Reader) (*ResolvConf, error) {
+	rc := &ResolvConf{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) < 2 {
+				continue
+			}
+			// drop the zone of link-local IPv6 addresses like fe80::1%eth0
+			addr, _, _ := strings.Cut(fields[1], "%")
+			if net.ParseIP(addr) != nil {
+				rc.Nameservers = append(rc.Nameservers, addr)
+			}
+		case "search":
+			rc.Search = fields[1:]
+		case "domain":
+			if len(fields) > 1 {
+				rc.Search = fields[1:2]
+			}
+		case "options":
+			rc.Options = append(rc.Options, fields[1:]...)
+		}
+	}
+	return rc, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package resolvconf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  ResolvConf
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  ResolvConf{},
+		},
+		{
+			name: "systemd-resolved",
+			input: `# This is /run/systemd/resolve/resolv.conf managed by man:systemd-resolved(8).
+nameserver 10.0.0.2
+nameserver 2001:db8::53
+search ec2.internal
+`,
+			want: ResolvConf{Nameservers: []string{"10.0.0.2", "2001:db8::53"}, Search: []string{"ec2.internal"}},
+		},
+		{
+			name: "comments and invalid nameservers",
+			input: `; comment
+# nameserver 192.0.2.1
+nameserver
+nameserver dns.example.com
+nameserver fe80::1%eth0
+nameserver 192.0.2.2 # trailing
+`,
+			want: ResolvConf{Nameservers: []string{"fe80::1", "192.0.2.2"}},
+		},
+		{
+			name: "last of search and domain wins",
+			input: `search a.example b.example
+domain c.example
+`,
+			want: ResolvConf{Search: []string{"c.example"}},
+		},
+		{
+			name: "options accumulate",
+			input: `options ndots:5
+options timeout:2 attempts:3
+`,
+			want: ResolvConf{Options: []string{"ndots:5", "timeout:2", "attempts:3"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package resolvconf
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Watcher polls a resolv.conf and reports changes of its nameservers
+type Watcher struct {
+	path   string
+	period time.Duration
+
+	lock        sync.RWMutex
+	nameservers []string
+	lastError   string
+	changes     chan struct{}
+}
+
+// WatcherStatus reports the nameservers read from the watched file
+type WatcherStatus struct {
+	Path        string   `json:"path"`
+	Nameservers []string `json:"nameservers"`
+	Error       string   `json:"error,omitempty"`
+}
+
+func NewWatcher(path string, period time.Duration) *Watcher {
+	return &Watcher{
+		path:    path,
+		period:  period,
+		changes: make(chan struct{}, 1),
+	}
+}
+
+// Start reads the file and polls it every period until stopCh is closed
+func (w *Watcher) Start(stopCh <-chan struct{}) {
+	w.load()
+	go func() {
+		ticker := time.NewTicker(w.period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if w.load() {
+					select {
+					case w.changes <- struct{}{}:
+					default:
+					}
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Changes is notified every time the nameservers change
+func (w *Watcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Nameservers returns the nameservers of the last successful read
+func (w *Watcher) Nameservers() []string {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.nameservers
+}
+
+func (w *Watcher) Status() interface{} {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return WatcherStatus{Path: w.path, Nameservers: w.nameservers, Error: w.lastError}
+}
+
+// load reads the file and returns true when the nameservers changed, the
+// previous nameservers are kept while the file cannot be read
+func (w *Watcher) load() bool {
+	rc, err := ParseFile(w.path)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err != nil {
+		if err.Error() != w.lastError {
+			klog.Warningf("Unable to read %s: %v", w.path, err)
+		}
+		w.lastError = err.Error()
+		return false
+	}
+	w.lastError = ""
+
+	if reflect.DeepEqual(rc.Nameservers, w.nameservers) {
+		return false
+	}
+	klog.V(1).Infof("Nameservers in %s changed to %v", w.path, rc.Nameservers)
+	w.nameservers = rc.Nameservers
+	return true
+}